@@ -0,0 +1,380 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// deleteAccount confirms the deletion of the account with the password
+type deleteAccount struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// accountDeletion is the response of deleting and restoring the account
+type accountDeletion struct {
+	Result            string     `json:"result"`
+	DeletionScheduled *time.Time `json:"deletionScheduled"`
+}
+
+// accountExport is everything stored about a user. Weights are in kilograms as they are stored.
+type accountExport struct {
+	Exported      time.Time       `json:"exported"`
+	Account       exportedAccount `json:"account"`
+	Authorities   []string        `json:"authorities"`
+	Exercises     []exercise      `json:"exercises"`
+	Workouts      []workout       `json:"workouts"`
+	Sets          []set           `json:"sets"`
+	Identities    []identity      `json:"identities"`
+	APIKeys       []apiKey        `json:"apiKeys"`
+	Sessions      []session       `json:"sessions"`
+	RefreshTokens []exportedToken `json:"refreshTokens"`
+	AuditLog      []auditEntry    `json:"auditLog"`
+}
+
+type exportedAccount struct {
+	UserID            string     `json:"userId"`
+	Username          string     `json:"username"`
+	Enabled           bool       `json:"enabled"`
+	Verified          bool       `json:"verified"`
+	TOTPEnabled       bool       `json:"totpEnabled"`
+	WeightUnit        string     `json:"weightUnit"`
+	DeletionScheduled *time.Time `json:"deletionScheduled"`
+	Created           time.Time  `json:"created"`
+	Modified          time.Time  `json:"modified"`
+}
+
+// exportedToken is a refresh token without the token itself, which isn't stored
+type exportedToken struct {
+	FamilyID string    `json:"familyId"`
+	Expires  time.Time `json:"expires"`
+	Used     bool      `json:"used"`
+	Revoked  bool      `json:"revoked"`
+	Created  time.Time `json:"created"`
+}
+
+// handleDeleteAccount schedules the account to be deleted after the grace period, during which the
+// user can still log in and restore the account
+func (s *Server) handleDeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request deleteAccount
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// The password is required, so that a stolen token isn't enough for deleting the account
+		user := user{UserID: claims.UserID}
+		if err := user.getUserByID(s.DB); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+
+		deletionScheduled := time.Now().Add(s.AccountDeletionGrace)
+		if err := scheduleAccountDeletion(s.DB, claims.UserID, &deletionScheduled); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The deletion is done anyway, so failing to tell about it is only logged
+		body := fmt.Sprintf("Your account and all your data will be deleted on %s.\n\nIf you didn't ask for deleting your account, log in at %s and restore it before that.",
+			deletionScheduled.UTC().Format(time.RFC1123), s.AppURL)
+		if err := s.Mailer.Send(user.Username, "Your account will be deleted", body); err != nil {
+			log.Println(err.Error())
+		}
+
+		respondWithJSON(w, http.StatusAccepted, accountDeletion{Result: "success", DeletionScheduled: &deletionScheduled})
+	}
+}
+
+// handleRestoreAccount cancels the scheduled deletion of the account
+func (s *Server) handleRestoreAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		if err := scheduleAccountDeletion(s.DB, claims.UserID, nil); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, accountDeletion{Result: "success"})
+	}
+}
+
+func (s *Server) handleExportAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		export, err := exportAccount(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="gymlog-account.json"`)
+		respondWithJSON(w, http.StatusOK, export)
+	}
+}
+
+// exportAccount reads everything stored about the user. The export is read in one transaction,
+// so that it is consistent even if the user changes the data at the same time.
+func exportAccount(db *sql.DB, userID string) (*accountExport, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	export := accountExport{Exported: time.Now(), Account: exportedAccount{UserID: userID}}
+	if err := export.Account.getExportedAccount(tx); err != nil {
+		return nil, err
+	}
+	if export.Authorities, err = getAuthorities(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.Exercises, err = exportExercises(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.Workouts, err = exportWorkouts(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.Sets, err = exportAccountSets(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = getIdentities(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = getAPIKeys(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.Sessions, err = exportSessions(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.RefreshTokens, err = exportRefreshTokens(tx, userID); err != nil {
+		return nil, err
+	}
+	if export.AuditLog, err = getAuditLog(tx, 0, math.MaxInt32, userID); err != nil {
+		return nil, err
+	}
+
+	return &export, tx.Commit()
+}
+
+func (a *exportedAccount) getExportedAccount(db queryer) error {
+	var enabled int
+	if err := db.QueryRow(
+		"SELECT username, enabled, verified, totp_enabled, weight_unit, deletion_scheduled, created, modified FROM users WHERE user_id=$1",
+		a.UserID).Scan(&a.Username, &enabled, &a.Verified, &a.TOTPEnabled, &a.WeightUnit, &a.DeletionScheduled, &a.Created, &a.Modified); err != nil {
+		return err
+	}
+	a.Enabled = enabled != 0
+	return nil
+}
+
+// exportExercises returns the custom exercises of the user, built-in exercises aren't personal data
+func exportExercises(db queryer, userID string) ([]exercise, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, name, muscle_group, equipment, category, created, modified FROM exercises WHERE user_id=$1 ORDER BY id ASC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []exercise{}
+	for rows.Next() {
+		var e exercise
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Name, &e.MuscleGroup, &e.Equipment, &e.Category, &e.Created, &e.Modified); err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, e)
+	}
+
+	return exercises, rows.Err()
+}
+
+func exportWorkouts(db queryer, userID string) ([]workout, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, started, ended, notes, location, created, modified FROM workouts WHERE user_id=$1 ORDER BY started ASC, id ASC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []workout{}
+	for rows.Next() {
+		var wo workout
+		if err := rows.Scan(&wo.ID, &wo.UserID, &wo.Started, &wo.Ended, &wo.Notes, &wo.Location, &wo.Created, &wo.Modified); err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, wo)
+	}
+
+	return workouts, rows.Err()
+}
+
+func exportAccountSets(db queryer, userID string) ([]set, error) {
+	rows, err := db.Query(
+		"SELECT "+setColumns+" FROM sets s JOIN exercises e ON e.id=s.exercise_id WHERE s.user_id=$1 ORDER BY s.created ASC, s.id ASC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := []set{}
+	for rows.Next() {
+		var s set
+		if err := s.scan(rows); err != nil {
+			return nil, err
+		}
+		s.Unit = unitKilograms
+		sets = append(sets, s)
+	}
+
+	return sets, rows.Err()
+}
+
+func exportRefreshTokens(db queryer, userID string) ([]exportedToken, error) {
+	rows, err := db.Query(
+		"SELECT family_id, expires, used, revoked, created FROM refresh_tokens WHERE user_id=$1 ORDER BY created ASC",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []exportedToken{}
+	for rows.Next() {
+		var t exportedToken
+		if err := rows.Scan(&t.FamilyID, &t.Expires, &t.Used, &t.Revoked, &t.Created); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// scheduleAccountDeletion sets the time when the account is deleted, nil cancels the deletion
+func scheduleAccountDeletion(db queryer, userID string, deletionScheduled *time.Time) error {
+	_, err := db.Exec("UPDATE users SET deletion_scheduled=$2, modified=$3 WHERE user_id=$1", userID, deletionScheduled, time.Now())
+	return err
+}
+
+// deleteScheduledAccounts deletes the accounts whose grace period has ended. Each account is deleted
+// in its own transaction, so that one failure doesn't prevent deleting the others.
+func deleteScheduledAccounts(db *sql.DB) error {
+	rows, err := db.Query("SELECT user_id FROM users WHERE deletion_scheduled <= $1", time.Now())
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if err := deleteUserData(db, userID); err != nil {
+			return err
+		}
+		log.Printf("Deleted account %s", userID)
+	}
+	return nil
+}
+
+// deleteUserData deletes the user and all their data in one transaction. The audit log of
+// administrators is kept, it refers to the user only with the ID.
+func deleteUserData(db *sql.DB, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Sets and workouts are deleted with the user. Login failures are keyed by the username, so they are
+	// deleted before the user, and the own exercises of the user only after the sets referring to them.
+	queries := []string{
+		"DELETE FROM authorities WHERE user_id=$1",
+		"DELETE FROM refresh_tokens WHERE user_id=$1",
+		"DELETE FROM revoked_tokens WHERE user_id=$1",
+		"DELETE FROM sessions WHERE user_id=$1",
+		"DELETE FROM password_reset_tokens WHERE user_id=$1",
+		"DELETE FROM email_verification_tokens WHERE user_id=$1",
+		"DELETE FROM login_challenges WHERE user_id=$1",
+		"DELETE FROM totp_recovery_codes WHERE user_id=$1",
+		"DELETE FROM oidc_states WHERE user_id=$1",
+		"DELETE FROM user_identities WHERE user_id=$1",
+		"DELETE FROM api_keys WHERE user_id=$1",
+		"DELETE FROM login_failures WHERE key=(SELECT 'user:' || lower(username) FROM users WHERE user_id=$1)",
+		"DELETE FROM login_failures WHERE key=(SELECT 'reset:user:' || lower(username) FROM users WHERE user_id=$1)",
+		"DELETE FROM login_failures WHERE key=(SELECT 'verify:user:' || lower(username) FROM users WHERE user_id=$1)",
+		"DELETE FROM users WHERE user_id=$1",
+		"DELETE FROM exercises WHERE user_id=$1",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExportAccount(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/users/me/export", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var export accountExport
+	json.Unmarshal(response.Body.Bytes(), &export)
+	if export.Account.Username != "user1@localhost.com" {
+		t.Errorf("Expected username user1@localhost.com. Got %s", export.Account.Username)
+	}
+	if len(export.Sets) != 1 || export.Sets[0].Weight != 100 || export.Sets[0].Exercise != "Squat" {
+		t.Errorf("Expected the set of the user. Got %v", export.Sets)
+	}
+	if len(export.RefreshTokens) != 1 {
+		t.Errorf("Expected 1 refresh token. Got %d", len(export.RefreshTokens))
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	// Deleting requires the password
+	req, _ = http.NewRequest("DELETE", "/api/users/me", bytes.NewBuffer([]byte(`{"password":"wrongpassword"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me", bytes.NewBuffer([]byte(`{"password":"password1"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, response.Code)
+	if testMailer.lastEmail("user1@localhost.com") == nil {
+		t.Error("Expected an email about the deletion")
+	}
+
+	// Nothing is deleted during the grace period, and the deletion can be cancelled
+	if err := deleteScheduledAccounts(testServer.DB); err != nil {
+		t.Fatal(err.Error())
+	}
+	req, _ = http.NewRequest("POST", "/api/users/me/restore", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me", bytes.NewBuffer([]byte(`{"password":"password1"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusAccepted, response.Code)
+
+	// After the grace period, the user and all their data are deleted
+	testServer.DB.Exec("INSERT INTO login_failures(key, failures, last_failure) VALUES('user:user1@localhost.com', 1, $1)", time.Now())
+	testServer.DB.Exec("UPDATE users SET deletion_scheduled=$2 WHERE user_id=$1", userIDs[0], time.Now().Add(-time.Minute))
+	if err := deleteScheduledAccounts(testServer.DB); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var count int
+	testServer.DB.QueryRow("SELECT COUNT(*) FROM sets WHERE user_id=$1", userIDs[0]).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no sets. Got %d", count)
+	}
+	testServer.DB.QueryRow("SELECT COUNT(*) FROM login_failures WHERE key='user:user1@localhost.com'").Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no login failures. Got %d", count)
+	}
+	testServer.DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if count != 1 {
+		t.Errorf("Expected only the other user. Got %d users", count)
+	}
+
+	jsonStr = []byte(`{"username":"user1@localhost.com", "password": "password1"}`)
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
@@ -0,0 +1,523 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Actions recorded in the audit log
+const (
+	auditEnableUser         = "enableUser"
+	auditDisableUser        = "disableUser"
+	auditForcePasswordReset = "forcePasswordReset"
+	auditGrantAuthority     = "grantAuthority"
+	auditRevokeAuthority    = "revokeAuthority"
+)
+
+// adminUser is a user as seen by administrators, including the usage of the account
+type adminUser struct {
+	UserID      string     `json:"userId"`
+	Username    string     `json:"username"`
+	Enabled     bool       `json:"enabled"`
+	Verified    bool       `json:"verified"`
+	TOTPEnabled bool       `json:"totpEnabled"`
+	Authorities []string   `json:"authorities"`
+	Sets        int        `json:"sets"`
+	Workouts    int        `json:"workouts"`
+	LastSet     *time.Time `json:"lastSet"`
+	Created     time.Time  `json:"created"`
+	Modified    time.Time  `json:"modified"`
+}
+
+type adminUsers struct {
+	Results int         `json:"results"`
+	Skip    int         `json:"skip"`
+	Limit   int         `json:"limit"`
+	Users   []adminUser `json:"users"`
+}
+
+type authorityRequest struct {
+	Authority string `json:"authority" validate:"required,alphanum,lowercase,max=50"`
+}
+
+// auditEntry is an action of an administrator. Details depend on the action, e.g. the granted authority.
+type auditEntry struct {
+	ID            int       `json:"id"`
+	AdminUserID   string    `json:"adminUserId"`
+	AdminUsername string    `json:"adminUsername"`
+	Action        string    `json:"action"`
+	TargetUserID  string    `json:"targetUserId"`
+	Details       string    `json:"details"`
+	Created       time.Time `json:"created"`
+}
+
+type auditLog struct {
+	Results int          `json:"results"`
+	Skip    int          `json:"skip"`
+	Limit   int          `json:"limit"`
+	Entries []auditEntry `json:"entries"`
+}
+
+const adminUserColumns = `u.user_id, u.username, u.enabled, u.verified, u.totp_enabled, u.created, u.modified,
+	(SELECT COALESCE(string_agg(a.authority, ',' ORDER BY a.authority), '') FROM authorities a WHERE a.user_id=u.user_id),
+	(SELECT COUNT(*) FROM sets s WHERE s.user_id=u.user_id),
+	(SELECT MAX(s.created) FROM sets s WHERE s.user_id=u.user_id),
+	(SELECT COUNT(*) FROM workouts w WHERE w.user_id=u.user_id)`
+
+func (s *Server) handleAdminGetUsers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		skip, _ := strconv.Atoi(r.FormValue("skip"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+
+		if limit < 1 {
+			limit = 10
+		}
+		if limit > s.MaxPageSize {
+			limit = s.MaxPageSize
+		}
+		if skip < 0 {
+			skip = 0
+		}
+
+		users := adminUsers{Skip: skip, Limit: limit}
+		result, err := getAdminUsers(s.DB, skip, limit, r.FormValue("q"))
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		users.Users = result
+		users.Results = len(result)
+		respondWithJSON(w, http.StatusOK, users)
+	}
+}
+
+func (s *Server) handleAdminGetUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := adminUser{UserID: mux.Vars(r)["id"]}
+		if err := user.getAdminUser(s.DB); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusNotFound, "User not found")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, user)
+	}
+}
+
+// handleAdminSetEnabled enables or disables a user. Disabled users are logged out and can't log in.
+func (s *Server) handleAdminSetEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		userID := mux.Vars(r)["id"]
+		if !enabled && userID == claims.UserID {
+			respondWithError(w, http.StatusBadRequest, "Can't disable your own account")
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		affectedRows, err := setUserEnabled(tx, userID, enabled)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+
+		action := auditEnableUser
+		if !enabled {
+			action = auditDisableUser
+			if err := revokeUserTokens(tx, userID, time.Now()); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+		}
+		if err := recordAudit(tx, claims.UserID, action, userID, ""); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// handleAdminResetPassword makes the password of the user unusable, logs the user out and emails a link
+// for setting a new password
+func (s *Server) handleAdminResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		user := user{UserID: mux.Vars(r)["id"]}
+		if err := user.getUserByID(tx); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusNotFound, "User not found")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		// Nobody knows the new password, so the user can log in only after resetting it
+		randomPassword, err := newRandomToken()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), passwordHashCost)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := updatePassword(tx, user.UserID, string(hashedPassword)); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := revokeUserTokens(tx, user.UserID, time.Now()); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := recordAudit(tx, claims.UserID, auditForcePasswordReset, user.UserID, ""); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The email is sent before committing, so that nothing changes if it can't be sent
+		if err := s.sendPasswordResetEmail(tx, &user); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleAdminGrantAuthority() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request authorityRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.changeAuthority(w, claims, mux.Vars(r)["id"], request.Authority, true)
+	}
+}
+
+// handleAdminRevokeAuthority revokes an authority of a user. The user is logged out, so that the
+// authority can't be used with the tokens created before.
+func (s *Server) handleAdminRevokeAuthority() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		if vars["id"] == claims.UserID && vars["authority"] == roleAdmin {
+			respondWithError(w, http.StatusBadRequest, "Can't revoke your own admin role")
+			return
+		}
+
+		s.changeAuthority(w, claims, vars["id"], vars["authority"], false)
+	}
+}
+
+// changeAuthority grants or revokes the authority of the user and responds with the updated user
+func (s *Server) changeAuthority(w http.ResponseWriter, claims *Claims, userID, authority string, grant bool) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer tx.Rollback()
+
+	user := adminUser{UserID: userID}
+	if err := user.getAdminUser(tx); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			log.Println(err.Error())
+			respondWithError(w, http.StatusNotFound, "User not found")
+		default:
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+
+	var affectedRows int64
+	action := auditGrantAuthority
+	if grant {
+		affectedRows, err = grantAuthority(tx, userID, authority)
+	} else {
+		action = auditRevokeAuthority
+		affectedRows, err = revokeAuthority(tx, userID, authority)
+		if err == nil && affectedRows > 0 {
+			err = revokeUserTokens(tx, userID, time.Now())
+		}
+	}
+	if err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !grant && affectedRows == 0 {
+		respondWithError(w, http.StatusNotFound, "Authority not found")
+		return
+	}
+
+	// Granting an authority the user already has changes nothing, so there is nothing to record
+	if affectedRows > 0 {
+		if err := recordAudit(tx, claims.UserID, action, userID, authority); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+	if err := user.getAdminUser(tx); err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) handleAdminGetAuditLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		skip, _ := strconv.Atoi(r.FormValue("skip"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+
+		if limit < 1 {
+			limit = 10
+		}
+		if limit > s.MaxPageSize {
+			limit = s.MaxPageSize
+		}
+		if skip < 0 {
+			skip = 0
+		}
+
+		entries := auditLog{Skip: skip, Limit: limit}
+		result, err := getAuditLog(s.DB, skip, limit, r.FormValue("userId"))
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		entries.Entries = result
+		entries.Results = len(result)
+		respondWithJSON(w, http.StatusOK, entries)
+	}
+}
+
+func (u *adminUser) scan(row scanner) error {
+	var enabled int
+	var authorities string
+	var lastSet sql.NullTime
+	if err := row.Scan(&u.UserID, &u.Username, &enabled, &u.Verified, &u.TOTPEnabled, &u.Created, &u.Modified,
+		&authorities, &u.Sets, &lastSet, &u.Workouts); err != nil {
+		return err
+	}
+
+	u.Enabled = enabled != 0
+	u.Authorities = []string{}
+	if authorities != "" {
+		u.Authorities = strings.Split(authorities, ",")
+	}
+	u.LastSet = nil
+	if lastSet.Valid {
+		u.LastSet = &lastSet.Time
+	}
+	return nil
+}
+
+func (u *adminUser) getAdminUser(db queryer) error {
+	return u.scan(db.QueryRow("SELECT "+adminUserColumns+" FROM users u WHERE u.user_id=$1", u.UserID))
+}
+
+// getAdminUsers returns the users whose username contains the query, ordered by username
+func getAdminUsers(db queryer, skip, limit int, query string) ([]adminUser, error) {
+	rows, err := db.Query(
+		"SELECT "+adminUserColumns+" FROM users u WHERE u.username ILIKE '%' || $1 || '%' ORDER BY u.username ASC LIMIT $2 OFFSET $3",
+		query, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []adminUser{}
+	for rows.Next() {
+		var u adminUser
+		if err := u.scan(rows); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+func setUserEnabled(db queryer, userID string, enabled bool) (int64, error) {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	result, err := db.Exec("UPDATE users SET enabled=$2, modified=$3 WHERE user_id=$1", userID, value, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func grantAuthority(db queryer, userID, authority string) (int64, error) {
+	current := time.Now()
+	result, err := db.Exec(
+		"INSERT INTO authorities(user_id, authority, created, modified) VALUES($1, $2, $3, $3) ON CONFLICT (user_id, authority) DO NOTHING",
+		userID, authority, current)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func revokeAuthority(db queryer, userID, authority string) (int64, error) {
+	result, err := db.Exec("DELETE FROM authorities WHERE user_id=$1 AND authority=$2", userID, authority)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// recordAudit records an action of an administrator
+func recordAudit(db queryer, adminUserID, action, targetUserID, details string) error {
+	_, err := db.Exec(
+		"INSERT INTO admin_audit_log(admin_user_id, action, target_user_id, details, created) VALUES($1, $2, $3, $4, $5)",
+		adminUserID, action, targetUserID, details, time.Now())
+	return err
+}
+
+// getAuditLog returns the audit log from the newest to the oldest, optionally only for actions targeting the user
+func getAuditLog(db queryer, skip, limit int, targetUserID string) ([]auditEntry, error) {
+	rows, err := db.Query(
+		`SELECT l.id, l.admin_user_id, COALESCE(u.username, ''), l.action, l.target_user_id, l.details, l.created
+		FROM admin_audit_log l LEFT JOIN users u ON u.user_id=l.admin_user_id
+		WHERE $1='' OR l.target_user_id=$1 ORDER BY l.created DESC, l.id DESC LIMIT $2 OFFSET $3`,
+		targetUserID, limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []auditEntry{}
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(&e.ID, &e.AdminUserID, &e.AdminUsername, &e.Action, &e.TargetUserID, &e.Details, &e.Created); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
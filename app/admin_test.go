@@ -0,0 +1,120 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdminUsers(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	testServer.DB.Exec("INSERT INTO authorities(user_id, authority, created, modified) VALUES($1, $2, $3, $3)", userIDs[0], roleAdmin, time.Now())
+	admin := authenticate("user1@localhost.com", "password1")
+	user := authenticate("user2@localhost.com", "password2")
+
+	// Only administrators can use the admin API
+	req, _ := http.NewRequest("GET", "/api/admin/users", nil)
+	req.AddCookie(user)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/admin/users?q=user2", nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var users adminUsers
+	json.Unmarshal(response.Body.Bytes(), &users)
+	if users.Results != 1 || users.Users[0].UserID != userIDs[1] || !users.Users[0].Enabled || users.Users[0].Sets != 0 {
+		t.Errorf("Expected user2 in the results. Got %s", response.Body.String())
+	}
+
+	// Disabled users are logged out and can't log in
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/disable", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(user)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	var jsonStr = []byte(`{"username":"user2@localhost.com", "password": "password2"}`)
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/enable", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// Administrators can't lock themselves out
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/disable", userIDs[0]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/admin/users/notfound/disable", nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// Granting and revoking authorities
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/authorities", userIDs[1]), bytes.NewBuffer([]byte(`{"authority":"coach"}`)))
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var updated adminUser
+	json.Unmarshal(response.Body.Bytes(), &updated)
+	if len(updated.Authorities) != 1 || updated.Authorities[0] != "coach" {
+		t.Errorf("Expected authority coach. Got %v", updated.Authorities)
+	}
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/admin/users/%s/authorities/coach", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/admin/users/%s/authorities/coach", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// Forced password reset makes the old password unusable
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/admin/users/%s/password/reset", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	emailToken(t, "user2@localhost.com")
+
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// Every action is recorded with the administrator who did it
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/admin/audit?userId=%s", userIDs[1]), nil)
+	req.AddCookie(admin)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var audit auditLog
+	json.Unmarshal(response.Body.Bytes(), &audit)
+	actions := ""
+	for _, entry := range audit.Entries {
+		if entry.AdminUserID != userIDs[0] || entry.AdminUsername != "user1@localhost.com" {
+			t.Errorf("Expected the action by user1. Got %s", entry.AdminUsername)
+		}
+		actions += entry.Action + ","
+	}
+	if actions != "forcePasswordReset,revokeAuthority,grantAuthority,enableUser,disableUser," {
+		t.Errorf("Unexpected audit log %s", actions)
+	}
+}
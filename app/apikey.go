@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix starts every API key, so that keys can be told apart from access tokens and found by secret scanners
+const apiKeyPrefix = "gymlog_"
+
+// apiKeyLastUsedPrecision is how often the last use of a key is updated, so that every request doesn't write
+const apiKeyLastUsedPrecision = time.Minute
+
+// Scopes limit what API keys can be used for. Access tokens of logged in users have every scope.
+const (
+	scopeSetsRead       = "sets:read"
+	scopeSetsWrite      = "sets:write"
+	scopeExercisesRead  = "exercises:read"
+	scopeExercisesWrite = "exercises:write"
+	scopeWorkoutsRead   = "workouts:read"
+	scopeWorkoutsWrite  = "workouts:write"
+)
+
+// claimsContextKey is the key of the claims of an API key in the context of the request
+type claimsContextKey struct{}
+
+// apiKey is a long-lived credential of a user for scripts and integrations. Only the hash of the key is stored.
+type apiKey struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"-"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Hash     string     `json:"-"`
+	Scopes   []string   `json:"scopes"`
+	LastUsed *time.Time `json:"lastUsed"`
+	Created  time.Time  `json:"created"`
+}
+
+type apiKeys struct {
+	Results int      `json:"results"`
+	APIKeys []apiKey `json:"apiKeys"`
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=sets:read sets:write exercises:read exercises:write workouts:read workouts:write"`
+}
+
+// createdAPIKey is the response of creating a key, the only time the key itself is shown
+type createdAPIKey struct {
+	apiKey
+	Key string `json:"key"`
+}
+
+func (s *Server) handleCreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		id, err := uuid.NewRandom()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		secret, err := newRandomToken()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		key := apiKeyPrefix + secret
+		k := apiKey{
+			ID:      id.String(),
+			UserID:  claims.UserID,
+			Name:    request.Name,
+			Prefix:  key[:len(apiKeyPrefix)+6],
+			Hash:    hashToken(key),
+			Scopes:  uniqueScopes(request.Scopes),
+			Created: time.Now(),
+		}
+		if err := k.createAPIKey(s.DB); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, createdAPIKey{apiKey: k, Key: key})
+	}
+}
+
+func (s *Server) handleGetAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		keys, err := getAPIKeys(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, apiKeys{Results: len(keys), APIKeys: keys})
+	}
+}
+
+func (s *Server) handleRevokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		affectedRows, err := deleteAPIKey(s.DB, claims.UserID, mux.Vars(r)["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// authenticateScope is authenticate for the routes that API keys with the scope can use as well.
+// The claims of the key are passed to the handler in the context of the request, where readClaims finds them.
+func (s *Server) authenticateScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	authenticated := s.authenticate(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := extractToken(r)
+		if err != nil || !strings.HasPrefix(key, apiKeyPrefix) {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := s.useAPIKey(key)
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if !claims.hasScope(scope) {
+			log.Printf("API key %s doesn't have the scope %s", claims.Id, scope)
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	}
+}
+
+// hasScope returns true if the API key has the scope. Access tokens have every scope.
+func (c *Claims) hasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// useAPIKey returns the claims of an API key of an enabled user and records the use of the key
+func (s *Server) useAPIKey(key string) (*Claims, error) {
+	var k apiKey
+	var username string
+	err := s.DB.QueryRow(
+		`SELECT k.id, k.user_id, u.username, k.scopes, k.last_used FROM api_keys k JOIN users u ON u.user_id=k.user_id
+		WHERE k.key_hash=$1 AND u.enabled=1`,
+		hashToken(key)).Scan(&k.ID, &k.UserID, &username, pq.Array(&k.Scopes), &k.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	current := time.Now()
+	if k.LastUsed == nil || current.Sub(*k.LastUsed) >= apiKeyLastUsedPrecision {
+		if _, err := s.DB.Exec("UPDATE api_keys SET last_used=$2 WHERE id=$1", k.ID, current); err != nil {
+			return nil, err
+		}
+	}
+
+	// A key without scopes can't be used for anything, unlike an access token with nil scopes
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	claims := &Claims{Username: username, UserID: k.UserID, Scopes: k.Scopes}
+	claims.Id = k.ID
+	return claims, nil
+}
+
+// uniqueScopes returns the scopes without duplicates in the order they were given
+func uniqueScopes(scopes []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
+
+func (k *apiKey) createAPIKey(db queryer) error {
+	_, err := db.Exec("INSERT INTO api_keys(id, user_id, name, prefix, key_hash, scopes, created) VALUES($1, $2, $3, $4, $5, $6, $7)",
+		k.ID, k.UserID, k.Name, k.Prefix, k.Hash, pq.Array(k.Scopes), k.Created)
+	return err
+}
+
+func getAPIKeys(db queryer, userID string) ([]apiKey, error) {
+	rows, err := db.Query("SELECT id, user_id, name, prefix, scopes, last_used, created FROM api_keys WHERE user_id=$1 ORDER BY created",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []apiKey{}
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.LastUsed, &k.Created); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// deleteAPIKey revokes the key, which stops working immediately
+func deleteAPIKey(db queryer, userID, id string) (int64, error) {
+	result, err := db.Exec("DELETE FROM api_keys WHERE user_id=$1 AND id=$2", userID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
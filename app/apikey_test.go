@@ -0,0 +1,86 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	// Unknown scopes are rejected
+	req, _ := http.NewRequest("POST", "/api/users/me/keys", bytes.NewBuffer([]byte(`{"name":"Watch","scopes":["admin"]}`)))
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/users/me/keys", bytes.NewBuffer([]byte(`{"name":"Watch","scopes":["sets:read"]}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+	var created createdAPIKey
+	json.Unmarshal(response.Body.Bytes(), &created)
+	if created.Key == "" || created.Prefix == "" {
+		t.Fatalf("Expected the key in the response. Got '%s'", response.Body.String())
+	}
+
+	// The key reads sets but doesn't write them
+	req, _ = http.NewRequest("GET", "/api/v1/sets", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":10}`, getExerciseID("Squat")))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	// Keys can't be used for managing the account
+	req, _ = http.NewRequest("GET", "/api/users/me/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/users/me/keys", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var keys apiKeys
+	json.Unmarshal(response.Body.Bytes(), &keys)
+	if keys.Results != 1 || keys.APIKeys[0].LastUsed == nil {
+		t.Errorf("Expected one used key. Got '%s'", response.Body.String())
+	}
+	if bytes.Contains(response.Body.Bytes(), []byte(created.Key)) {
+		t.Error("Expected the key not to be listed")
+	}
+
+	// The keys of other users can't be revoked
+	req, _ = http.NewRequest("DELETE", "/api/users/me/keys/"+created.ID, nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// The key stops working when the account is disabled
+	setUserEnabled(testServer.DB, userIDs[0], false)
+	req, _ = http.NewRequest("GET", "/api/v1/sets", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+	setUserEnabled(testServer.DB, userIDs[0], true)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me/keys/"+created.ID, nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/v1/sets", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Key)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
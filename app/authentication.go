@@ -3,9 +3,10 @@ package app
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -13,22 +14,51 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_KEY"))
-
 type user struct {
 	Password string `json:"password" validate:"required"`
 	Username string `json:"username" validate:"required,email"`
 	UserID   string `json:"userId"`
+	// Enabled is 0 for disabled users, who can't log in
+	Enabled int `json:"-"`
+	// Verified is true after the user has verified their email address
+	Verified bool `json:"-"`
 }
 
 // Claims is a struct for JWT cookie
-// Includes embedded type jwt.StandardClaims to provide additional fields like expiry time
+// Includes embedded type jwt.StandardClaims to provide additional fields like expiry time,
+// issue time and the token ID (jti) used for revoking the token
 type Claims struct {
 	Username string `json:"username" validate:"required"`
 	UserID   string `json:"userId" validate:"required"`
+	// Roles are the authorities of the user when the token was created
+	Roles []string `json:"roles,omitempty"`
+	// Scopes are the scopes of an API key, they are nil for access tokens
+	Scopes []string `json:"-"`
+	// SessionID is the session of the login, which is the family of its refresh tokens
+	SessionID string `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
+// tokenResponse is the response of logging in and refreshing. The tokens are included only when asked
+// for with the parameter includeTokens, for clients that send the tokens in the Authorization header.
+type tokenResponse struct {
+	Result              string     `json:"result"`
+	AccessToken         string     `json:"accessToken,omitempty"`
+	AccessTokenExpires  *time.Time `json:"accessTokenExpires,omitempty"`
+	RefreshToken        string     `json:"refreshToken,omitempty"`
+	RefreshTokenExpires *time.Time `json:"refreshTokenExpires,omitempty"`
+}
+
+// refreshRequest contains the refresh token of clients that don't use cookies
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// logoutEverywhere contains the time before which all the tokens of the user are revoked
+type logoutEverywhere struct {
+	Before *time.Time `json:"before"`
+}
+
 func (s *Server) handleLogin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var creds user
@@ -50,100 +80,279 @@ func (s *Server) handleLogin() http.HandlerFunc {
 			return
 		}
 
-		// Authenticate user
+		// Failed logins are limited per username and per IP address, for both existing and unknown users
+		accountKey, ipKey := loginFailureKeys(creds.Username, s.clientIP(r))
+		lockedUntil, err := getLoginLockout(s.DB, accountKey, ipKey)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if lockedUntil != nil {
+			respondWithLockout(w, *lockedUntil)
+			return
+		}
+
+		// Authenticate user. The password is checked even if the user doesn't exist, and the response is
+		// the same for unknown users and wrong passwords, so that neither reveals which users exist.
 		user := creds
+		found := true
 		if err := user.getUserByUsername(s.DB); err != nil {
-			switch err {
-			case sql.ErrNoRows:
+			if err != sql.ErrNoRows {
 				log.Println(err.Error())
-				respondWithError(w, http.StatusNotFound, "User not found")
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
 				return
-			default:
+			}
+			found = false
+			user.Password = string(dummyPasswordHash)
+		}
+
+		// Check password: match => continue, not match => unauthorized
+		if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil || !found {
+			if err := s.recordLoginFailures(s.DB, accountKey, ipKey); err != nil {
 				log.Println(err.Error())
 				respondWithError(w, http.StatusInternalServerError, "Internal server error")
 				return
 			}
+			respondWithError(w, http.StatusUnauthorized, "Invalid username or password")
+			return
 		}
 
-		// Check password: match => continue, not match => unauthorized
-		if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
-			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		// The state of the account is checked only after the password, so that it isn't revealed to others
+		if user.Enabled == 0 {
+			respondWithError(w, http.StatusForbidden, "Account disabled")
+			return
+		}
+		if !user.Verified {
+			respondWithError(w, http.StatusForbidden, "Email address not verified")
 			return
 		}
 
-		// Create JWT claims, which include username and expiration time
-		expirationTime := time.Now().Add(1 * time.Minute)
-		claims := &Claims{
-			Username: user.Username,
-			UserID:   user.UserID,
-			StandardClaims: jwt.StandardClaims{
-				// In JWT, expiration time is given as unix milliseconds
-				ExpiresAt: expirationTime.Unix(),
-			},
+		// Users with two-factor authentication get the tokens only after entering the code
+		var totp totp
+		if err := totp.getTOTP(s.DB, user.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if totp.Enabled {
+			challenge, err := createLoginChallenge(s.DB, user.UserID)
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			respondWithJSON(w, http.StatusOK, loginChallengeResponse{Result: "totpRequired", Challenge: challenge})
+			return
+		}
+
+		// The failures are forgotten only after the whole login has succeeded, including the code of
+		// two-factor authentication
+		if err := clearLoginFailures(s.DB, accountKey); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
 		}
 
-		// Declare the token with algorithm used for signing and the claims
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		// Create JWT string
-		tokenString, err := token.SignedString(jwtKey)
+		// Each login starts a new session with its own family of refresh tokens
+		familyID, err := uuid.NewRandom()
 		if err != nil {
-			// In case of error, return internal server error
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
-		// Finally, we set the client cookie for token and use the same expiration time
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Expires:  expirationTime,
-			HttpOnly: true,
-			Path:     "/api",
-		})
+		tokens, err := s.createTokens(s.DB, r, user.UserID, user.Username, familyID.String())
+		if err != nil {
+			// In case of error, return internal server error
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
 
-		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+		// Finally, we set the client cookies for the tokens
+		tokens.setCookies(w)
+		respondWithJSON(w, http.StatusOK, tokens.response(r))
 	}
 }
 
 func (s *Server) handleRefresh() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Parse claims for user information
-		claims, err := parseTokenCookie(w, r)
+		// The access token may have expired already, so the refresh token is the only credential
+		refreshTokenValue := readRefreshToken(r)
+		if refreshTokenValue == "" {
+			respondWithError(w, http.StatusUnauthorized, "No refresh token present")
+			return
+		}
+
+		tx, err := s.DB.Begin()
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
+		defer tx.Rollback()
 
-		// We ensure that a new token is not issued until enough time has elapsed
-		// In this case, a new token will only be issued if the old token is within
-		// 30 seconds of expiry. Otherwise, return a bad request status
-		if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-			w.WriteHeader(http.StatusBadRequest)
+		var token refreshToken
+		if err := token.getRefreshToken(tx, refreshTokenValue); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		if token.Revoked || time.Now().After(token.Expires) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+
+		// A refresh token is used only once, so reusing it means that it has been stolen.
+		// Neither the thief nor the user can refresh anymore, and the user has to log in again.
+		if token.Used {
+			log.Printf("Refresh token reused, revoking token family %s", token.FamilyID)
+			if err := revokeTokenFamily(tx, token.FamilyID); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+			return
+		}
+
+		// Replace the refresh token with a new one of the same family
+		if err := token.markRefreshTokenUsed(tx); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		tokens, err := s.createTokens(tx, r, token.UserID, token.Username, token.FamilyID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		tokens.setCookies(w)
+		respondWithJSON(w, http.StatusOK, tokens.response(r))
+	}
+}
+
+func (s *Server) handleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		if err := revokeAccessToken(s.DB, claims); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The session of the access token is revoked with its refresh tokens, even if the refresh cookie isn't sent
+		if claims.SessionID != "" {
+			if err := revokeTokenFamily(s.DB, claims.SessionID); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+		}
+
+		// The refresh tokens of this login are revoked too, so that the access token can't be renewed
+		if refreshTokenValue := readRefreshToken(r); refreshTokenValue != "" {
+			var token refreshToken
+			err := token.getRefreshToken(s.DB, refreshTokenValue)
+			if err == nil && token.UserID == claims.UserID {
+				err = revokeTokenFamily(s.DB, token.FamilyID)
+			}
+			if err != nil && err != sql.ErrNoRows {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+		}
+
+		// Revocations are needed only until the tokens expire
+		if err := deleteExpiredRevocations(s.DB); err != nil {
+			log.Println(err.Error())
+		}
+
+		clearTokenCookies(w)
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleLogoutEverywhere() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
-		// Now, create a new token for the current use, with a renewed expiration time
-		expirationTime := time.Now().Add(1 * time.Minute)
-		claims.ExpiresAt = expirationTime.Unix()
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString(jwtKey)
+		// Logic
+		// The body is optional, by default every token issued until now is revoked
+		var logout logoutEverywhere
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&logout); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+				return
+			}
+			defer r.Body.Close()
+		}
+
+		before := time.Now()
+		if logout.Before != nil {
+			if logout.Before.After(before) {
+				respondWithError(w, http.StatusBadRequest, "Time can't be in the future")
+				return
+			}
+			before = *logout.Before
+		}
+
+		tx, err := s.DB.Begin()
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
+		defer tx.Rollback()
 
-		// Set the new token as the users `token` cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenString,
-			Expires:  expirationTime,
-			HttpOnly: true,
-			Path:     "/api",
-		})
+		if err := revokeUserTokens(tx, claims.UserID, before); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
 
+		clearTokenCookies(w)
 		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
 	}
 }
@@ -182,7 +391,7 @@ func (s *Server) handleRegister() http.HandlerFunc {
 
 		// Hash with bcrypt
 		// The second argument is the cost of hashing, which we arbitrarily set as 8 (this value can be more or less, depending on the computing power you wish to utilize)
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), 8)
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), passwordHashCost)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -205,22 +414,71 @@ func (s *Server) handleRegister() http.HandlerFunc {
 			return
 		}
 
+		// The user can't log in before verifying the email address. If sending the email fails,
+		// the user can ask for resending it.
+		creds.UserID = userID.String()
+		if err := s.sendVerificationEmail(s.DB, &creds); err != nil {
+			log.Println(err.Error())
+		}
+
 		respondWithJSON(w, http.StatusCreated, map[string]string{"result": "success"})
 	}
 }
 
-func parseTokenCookie(w http.ResponseWriter, r *http.Request) (*Claims, error) {
-	claims := &Claims{}
+// errNoToken means that the request has neither an Authorization header nor a token cookie
+var errNoToken = errors.New("no token present")
+
+// errAPIKeyNotAllowed means that an API key was sent to a route that only logged in users can use
+var errAPIKeyNotAllowed = errors.New("API key not allowed")
+
+// extractToken returns the access token of the request. Scripts and native apps send the token in
+// the Authorization header and the web client in the token cookie.
+func extractToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+			return "", errors.New("invalid authorization header")
+		}
+		return strings.TrimSpace(parts[1]), nil
+	}
+
 	c, err := r.Cookie("token")
 	if err != nil {
-		log.Println(err.Error())
-		respondWithError(w, http.StatusBadRequest, "Invalid token")
+		return "", errNoToken
+	}
+	return c.Value, nil
+}
+
+// readClaims extracts the access token of the request and verifies its signature and expiration time.
+// Both authenticate and the handlers read the claims through this, so they always agree on the token.
+// The claims of an API key have been verified by authenticateScope, which passes them in the context.
+func readClaims(r *http.Request) (*Claims, error) {
+	if claims, ok := r.Context().Value(claimsContextKey{}).(*Claims); ok {
+		return claims, nil
+	}
+
+	claims := &Claims{}
+	tokenString, err := extractToken(r)
+	if err != nil {
 		return claims, err
 	}
+	if strings.HasPrefix(tokenString, apiKeyPrefix) {
+		return claims, errAPIKeyNotAllowed
+	}
 
-	_, err = jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, tokenKeys.verificationKey)
+	if err != nil {
+		return claims, err
+	}
+	if !token.Valid {
+		return claims, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// parseToken returns the claims of the access token of an authenticated request
+func parseToken(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	claims, err := readClaims(r)
 	if err != nil {
 		log.Println(err.Error())
 		respondWithError(w, http.StatusBadRequest, "Invalid token")
@@ -229,11 +487,136 @@ func parseTokenCookie(w http.ResponseWriter, r *http.Request) (*Claims, error) {
 	return claims, nil
 }
 
-func (c *user) createUser(db *sql.DB, userID, hashedPassword string) error {
+// tokens are the credentials of a logged in user
+type tokens struct {
+	Access         string
+	AccessExpires  time.Time
+	Refresh        string
+	RefreshExpires time.Time
+}
+
+// createTokens creates a short-lived access token and stores a new long-lived refresh token of the token family.
+// The family is the session of the login, which is recorded with the device of the request.
+func (s *Server) createTokens(db queryer, r *http.Request, userID, username, familyID string) (tokens, error) {
+	current := time.Now()
+	tokens := tokens{AccessExpires: current.Add(s.AccessTokenTTL), RefreshExpires: current.Add(s.RefreshTokenTTL)}
+
+	// The roles are read on every login and refresh, so that granted and revoked roles take effect
+	roles, err := getAuthorities(db, userID)
+	if err != nil {
+		return tokens, err
+	}
+
+	// Create JWT claims, which include username, roles and expiration time
+	claims := &Claims{
+		Username:  username,
+		UserID:    userID,
+		Roles:     roles,
+		SessionID: familyID,
+		StandardClaims: jwt.StandardClaims{
+			// In JWT, expiration time is given as unix seconds
+			ExpiresAt: tokens.AccessExpires.Unix(),
+			IssuedAt:  current.Unix(),
+		},
+	}
+
+	// The token ID (jti) identifies the token in the revocation store
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return tokens, err
+	}
+	claims.Id = tokenID.String()
+
+	// Sign the token with the current signing key
+	if tokens.Access, err = tokenKeys.sign(claims); err != nil {
+		return tokens, err
+	}
+
+	if tokens.Refresh, err = newRandomToken(); err != nil {
+		return tokens, err
+	}
+	refreshToken := refreshToken{Hash: hashToken(tokens.Refresh), FamilyID: familyID, UserID: userID, Expires: tokens.RefreshExpires, Created: current}
+	if err := refreshToken.createRefreshToken(db); err != nil {
+		return tokens, err
+	}
+	if err := s.recordSession(db, r, familyID, userID, tokens.RefreshExpires); err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// setCookies sets the tokens as cookies. The refresh token is sent only to the user endpoints,
+// so it isn't exposed to the rest of the API.
+func (t *tokens) setCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    t.Access,
+		Expires:  t.AccessExpires,
+		HttpOnly: true,
+		Path:     "/api",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    t.Refresh,
+		Expires:  t.RefreshExpires,
+		HttpOnly: true,
+		Path:     "/api/users",
+	})
+}
+
+// response returns the response of logging in or refreshing, including the tokens if the client asked for them
+func (t *tokens) response(r *http.Request) tokenResponse {
+	if r.URL.Query().Get("includeTokens") != "true" {
+		return tokenResponse{Result: "success"}
+	}
+	return tokenResponse{
+		Result:              "success",
+		AccessToken:         t.Access,
+		AccessTokenExpires:  &t.AccessExpires,
+		RefreshToken:        t.Refresh,
+		RefreshTokenExpires: &t.RefreshExpires,
+	}
+}
+
+// readRefreshToken returns the refresh token of the request either from the refresh_token cookie or,
+// for clients that don't use cookies, from the body. Returns an empty string if there is no refresh token.
+func readRefreshToken(r *http.Request) string {
+	if c, err := r.Cookie("refresh_token"); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	var request refreshRequest
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&request)
+		r.Body.Close()
+	}
+	return request.RefreshToken
+}
+
+// clearTokenCookies removes the token cookies from the client
+func clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api/users",
+	})
+}
+
+func (c *user) createUser(db queryer, userID, hashedPassword string) error {
 	current := time.Now()
 	_, err := db.Exec(
-		"INSERT INTO users(user_id, username, password, created, modified) VALUES($1, $2, $3, $4, $5)",
-		userID, c.Username, hashedPassword, current, current)
+		"INSERT INTO users(user_id, username, password, verified, created, modified) VALUES($1, $2, $3, $4, $5, $6)",
+		userID, c.Username, hashedPassword, c.Verified, current, current)
 
 	if err != nil {
 		return err
@@ -267,8 +650,14 @@ func (c *user) checkIfUserExists(db *sql.DB) (bool, error) {
 	return false, nil
 }
 
-func (c *user) getUserByUsername(db *sql.DB) error {
+func (c *user) getUserByUsername(db queryer) error {
+	return db.QueryRow(
+		"SELECT user_id, username, password, enabled, verified FROM users WHERE username=$1",
+		c.Username).Scan(&c.UserID, &c.Username, &c.Password, &c.Enabled, &c.Verified)
+}
+
+func (c *user) getUserByID(db queryer) error {
 	return db.QueryRow(
-		"SELECT user_id, username, password FROM users WHERE username=$1",
-		c.Username).Scan(&c.UserID, &c.Username, &c.Password)
+		"SELECT user_id, username, password, enabled, verified FROM users WHERE user_id=$1",
+		c.UserID).Scan(&c.UserID, &c.Username, &c.Password, &c.Enabled, &c.Verified)
 }
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"log"
+	"net/http"
+)
+
+// roleAdmin is the role of administrators. Roles are the authorities of users, which are embedded in the
+// access token when it is created, so changes take effect when the user logs in again or refreshes the token.
+const roleAdmin = "admin"
+
+// requireRole allows the request only if the token of the user has the role. It is used inside
+// authenticate, which has already checked that the token is valid.
+func (s *Server) requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := readClaims(r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		if !claims.hasRole(role) {
+			log.Printf("User %s doesn't have the role %s", claims.UserID, role)
+			respondWithError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// hasRole returns true if the token has the role
+func (c *Claims) hasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// getAuthorities returns the roles of the user in alphabetical order
+func getAuthorities(db queryer, userID string) ([]string, error) {
+	rows, err := db.Query("SELECT authority FROM authorities WHERE user_id=$1 ORDER BY authority", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authorities := []string{}
+	for rows.Next() {
+		var authority string
+		if err := rows.Scan(&authority); err != nil {
+			return nil, err
+		}
+		authorities = append(authorities, authority)
+	}
+
+	return authorities, rows.Err()
+}
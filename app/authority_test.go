@@ -0,0 +1,33 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequireRole(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	current := time.Now()
+	testServer.DB.Exec("INSERT INTO authorities(user_id, authority, created, modified) VALUES($1, $2, $3, $3)", userIDs[0], roleAdmin, current)
+
+	handler := testServer.authenticate(testServer.requireRole(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}))
+
+	// The role is embedded in the token at login
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeHandler(handler, req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response = executeHandler(handler, req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	response = executeHandler(handler, req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
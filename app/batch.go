@@ -0,0 +1,175 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxBatchSize is the maximum number of operations in one batch
+const maxBatchSize = 500
+
+// setBatch contains set operations applied together in one transaction, in the order they are given
+type setBatch struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation creates or updates the set, or deletes the set with the ID
+type batchOperation struct {
+	Operation string `json:"operation"`
+	Set       *set   `json:"set,omitempty"`
+	ID        int    `json:"id,omitempty"`
+}
+
+// batchResult is the result of a single operation of a batch
+type batchResult struct {
+	Operation string `json:"operation"`
+	Index     int    `json:"index"`
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Set       *set   `json:"set,omitempty"`
+}
+
+type batchResults struct {
+	Result  string        `json:"result"`
+	Results []batchResult `json:"results"`
+}
+
+func (s *Server) handleSetBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var batch setBatch
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&batch); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		if len(batch.Operations) == 0 || len(batch.Operations) > maxBatchSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Batch must contain 1-%d operations", maxBatchSize))
+			return
+		}
+
+		// Weights are in the unit of each set or in the unit of the user
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		// Every operation is attempted, so that all the failing operations are reported at once.
+		// A database error aborts the transaction, so processing stops at the first one.
+		results := []batchResult{}
+		failures := map[int]int{}
+		fail := func(result batchResult, status int, message string) {
+			result.Status = status
+			result.Error = message
+			results = append(results, result)
+			failures[status]++
+		}
+
+		for i, operation := range batch.Operations {
+			result := batchResult{Operation: operation.Operation, Index: i}
+			switch operation.Operation {
+			case "create", "update":
+				if operation.Set == nil {
+					fail(result, http.StatusBadRequest, "Set is required")
+					continue
+				}
+				set := *operation.Set
+				if err := s.Validator.Struct(set); err != nil {
+					fail(result, http.StatusBadRequest, err.Error())
+					continue
+				}
+				if err := set.checkReferences(tx, claims.UserID); err != nil {
+					if err == errExerciseNotFound || err == errWorkoutNotFound {
+						fail(result, http.StatusBadRequest, err.Error())
+						continue
+					}
+					log.Println(err.Error())
+					respondWithError(w, http.StatusInternalServerError, "Internal server error")
+					return
+				}
+				set.toKilograms(unit)
+				if operation.Operation == "create" {
+					if err := set.createSet(tx, claims.UserID); err != nil {
+						log.Println(err.Error())
+						respondWithError(w, http.StatusInternalServerError, "Internal server error")
+						return
+					}
+					result.Status = http.StatusCreated
+				} else {
+					affectedRows, err := set.updateSet(tx, claims.UserID)
+					if err != nil {
+						log.Println(err.Error())
+						respondWithError(w, http.StatusInternalServerError, "Internal server error")
+						return
+					}
+					if affectedRows == 0 {
+						fail(result, http.StatusNotFound, "Not found")
+						continue
+					}
+					result.Status = http.StatusOK
+				}
+				set.fromKilograms(unit)
+				result.Set = &set
+			case "delete":
+				set := set{ID: operation.ID}
+				affectedRows, err := set.deleteSet(tx, claims.UserID)
+				if err != nil {
+					log.Println(err.Error())
+					respondWithError(w, http.StatusInternalServerError, "Internal server error")
+					return
+				}
+				if affectedRows == 0 {
+					fail(result, http.StatusNotFound, "Not found")
+					continue
+				}
+				result.Status = http.StatusOK
+			default:
+				fail(result, http.StatusBadRequest, "Operation must be create, update or delete")
+				continue
+			}
+			results = append(results, result)
+		}
+
+		// Nothing is saved if any of the operations failed. The batch is not found if only sets that were
+		// not found failed, and otherwise it is a bad request.
+		if len(failures) > 0 {
+			status := http.StatusBadRequest
+			if len(failures) == 1 && failures[http.StatusNotFound] > 0 {
+				status = http.StatusNotFound
+			}
+			respondWithJSON(w, status, batchResults{Result: "rolled back", Results: results})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, batchResults{Result: "success", Results: results})
+	}
+}
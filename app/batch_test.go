@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSetBatch(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addSets(userIDs)
+
+	// Create two sets, update and delete the existing one
+	squat := getExerciseID("Squat")
+	var jsonStr = []byte(fmt.Sprintf(`{"operations": [
+		{"operation": "create", "set": {"weight": 100, "exerciseId":%[1]d, "repetitions":5}},
+		{"operation": "create", "set": {"weight": 105, "exerciseId":%[1]d, "repetitions":5}},
+		{"operation": "update", "set": {"id": 1, "weight": 90, "exerciseId":%[1]d, "repetitions":8}},
+		{"operation": "delete", "id": 1}
+	]}`, squat))
+	req, _ := http.NewRequest("POST", "/api/v1/sets/batch", bytes.NewBuffer(jsonStr))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m batchResults
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if len(m.Results) != 4 {
+		t.Fatalf("Expected '4' results. Got '%v'", len(m.Results))
+	}
+	if m.Results[0].Set == nil || m.Results[0].Set.ID == 0 {
+		t.Errorf("Expected the created set to have an ID. Got '%v'", m.Results[0].Set)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/sets?total=true", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+
+	var n sets
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Total == nil || *n.Total != 2 {
+		t.Errorf("Expected the user to have '2' sets. Got '%v'", n.Total)
+	}
+}
+
+func TestSetBatchRollback(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addSets(userIDs)
+
+	// Second set has neither weight nor repetitions and the set 2 belongs to the other user
+	squat := getExerciseID("Squat")
+	var jsonStr = []byte(fmt.Sprintf(`{"operations": [
+		{"operation": "create", "set": {"weight": 100, "exerciseId":%[1]d, "repetitions":5}},
+		{"operation": "create", "set": {"exerciseId":%[1]d}},
+		{"operation": "delete", "id": 2}
+	]}`, squat))
+	req, _ := http.NewRequest("POST", "/api/v1/sets/batch", bytes.NewBuffer(jsonStr))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	var m batchResults
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if len(m.Results) != 3 || m.Results[1].Status != http.StatusBadRequest || m.Results[2].Status != http.StatusNotFound {
+		t.Errorf("Expected the second create and the delete to fail. Got '%+v'", m.Results)
+	}
+
+	// Nothing was created
+	req, _ = http.NewRequest("GET", "/api/v1/sets?total=true", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+
+	var n sets
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Total == nil || *n.Total != 1 {
+		t.Errorf("Expected the user to have '1' set. Got '%v'", n.Total)
+	}
+
+	// Operations are applied in order, so the deleted set can't be updated
+	jsonStr = []byte(fmt.Sprintf(`{"operations": [
+		{"operation": "delete", "id": 1},
+		{"operation": "update", "set": {"id": 1, "weight": 90, "exerciseId":%[1]d, "repetitions":8}}
+	]}`, squat))
+	req, _ = http.NewRequest("POST", "/api/v1/sets/batch", bytes.NewBuffer(jsonStr))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if len(m.Results) != 2 || m.Results[0].Status != http.StatusOK || m.Results[1].Status != http.StatusNotFound {
+		t.Errorf("Expected the update after the delete to fail. Got '%+v'", m.Results)
+	}
+}
@@ -0,0 +1,364 @@
+package app
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader contains the columns of exported sets. Imported files use the same columns, of which only
+// weight, repetitions and either exercise or exercise_id are required. Exercises are exported by their
+// names and workouts and sets by their keys, so that the file can be imported to any account, and
+// importing it again skips the sets imported already.
+var csvHeader = []string{"import_key", "created", "exercise", "weight", "repetitions", "unit", "rpe", "rir", "tempo",
+	"rest_seconds", "duration_seconds", "distance", "set_type", "notes", "workout", "workout_started"}
+
+// csvTimeLayouts are the accepted formats of timestamps in imported files, times without
+// a time zone are in UTC
+var csvTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", "2 Jan 2006, 15:04"}
+
+func (s *Server) handleExportCSV() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="gymlog-export.csv"`)
+
+		// Sets are written while they are read from the database, so the whole history is never in memory
+		writer := csv.NewWriter(w)
+		writer.Write(csvHeader)
+		err = exportSets(s.DB, claims.UserID, func(set set, workout *workout) error {
+			set.fromKilograms(unit)
+			workoutKey, workoutStarted := "", ""
+			if workout != nil {
+				workoutKey, workoutStarted = workout.ImportKey, workout.Started.Format(time.RFC3339)
+			}
+			return writer.Write([]string{
+				set.ImportKey,
+				set.Created.Format(time.RFC3339Nano),
+				set.Exercise,
+				strconv.FormatFloat(set.Weight, 'f', -1, 64),
+				strconv.Itoa(set.Repetitions),
+				set.Unit,
+				formatOptionalFloat(set.RPE),
+				formatOptionalInt(set.RIR),
+				set.Tempo,
+				formatOptionalInt(set.RestSeconds),
+				formatOptionalInt(set.DurationSeconds),
+				formatOptionalFloat(set.Distance),
+				set.SetType,
+				set.Notes,
+				workoutKey,
+				workoutStarted,
+			})
+		})
+		writer.Flush()
+
+		// The response has already started, so the error can only be logged
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// csvRecord is a row of a CSV file with the values mapped by lower-case column names
+type csvRecord struct {
+	Row    int
+	Values map[string]string
+	Err    error
+}
+
+// value returns the trimmed value of the column, or an empty string if the row doesn't have the column
+func (c *csvRecord) value(name string) string {
+	return strings.TrimSpace(c.Values[name])
+}
+
+// readCSV reads the rows of a CSV file with a header row. Both commas and semicolons are accepted as
+// delimiters. Rows that can't be read are returned with an error.
+func readCSV(file io.Reader, required ...string) ([]csvRecord, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	firstLine := strings.SplitN(string(data), "\n", 2)[0]
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("Missing header row")
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	}
+	for _, name := range required {
+		found := false
+		for _, column := range header {
+			found = found || column == name
+		}
+		if !found {
+			return nil, fmt.Errorf("Missing column %s", name)
+		}
+	}
+
+	var records []csvRecord
+	for row := 2; ; row++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				records = append(records, csvRecord{Row: row, Err: err})
+				continue
+			}
+			return nil, err
+		}
+
+		record := csvRecord{Row: row, Values: map[string]string{}}
+		for i, value := range values {
+			if i < len(header) {
+				record.Values[header[i]] = value
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// parseGymlogCSV parses sets from a file in the format of the export. Rows that can't be parsed are returned with an error.
+func parseGymlogCSV(file io.Reader) ([]importRow, error) {
+	records, err := readCSV(file, "weight", "repetitions")
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []importRow
+	workouts := map[string]*workout{}
+	for _, record := range records {
+		row := importRow{Row: record.Row, Exercise: record.value("exercise"), Err: record.Err}
+		row.Set.ImportKey = record.value("import_key")
+		row.Set.Unit = strings.ToLower(record.value("unit"))
+		row.Set.Tempo = record.value("tempo")
+		row.Set.SetType = record.value("set_type")
+		row.Set.Notes = record.value("notes")
+		if row.Err != nil {
+			rows = append(rows, row)
+			continue
+		}
+
+		if row.Exercise == "" && record.value("exercise_id") == "" {
+			row.Err = errors.New("Missing exercise")
+		} else if row.Set.Weight, err = parseOptionalFloat(record.value("weight")); err != nil {
+			row.Err = errors.New("Invalid weight")
+		} else if row.Set.Repetitions, err = parseOptionalInt(record.value("repetitions")); err != nil {
+			row.Err = errors.New("Invalid repetitions")
+		} else if row.Set.ExerciseID, err = parseOptionalInt(record.value("exercise_id")); err != nil {
+			row.Err = errors.New("Invalid exercise_id")
+		} else if row.Set.WorkoutID, err = parseOptionalIntPointer(record.value("workout_id")); err != nil {
+			row.Err = errors.New("Invalid workout_id")
+		} else if row.Set.Created, err = parseCSVTime(record.value("created")); err != nil {
+			row.Err = errors.New("Invalid created")
+		} else if row.Set.RPE, err = parseOptionalFloatPointer(record.value("rpe")); err != nil {
+			row.Err = errors.New("Invalid rpe")
+		} else if row.Set.RIR, err = parseOptionalIntPointer(record.value("rir")); err != nil {
+			row.Err = errors.New("Invalid rir")
+		} else if row.Set.RestSeconds, err = parseOptionalIntPointer(record.value("rest_seconds")); err != nil {
+			row.Err = errors.New("Invalid rest_seconds")
+		} else if row.Set.DurationSeconds, err = parseOptionalIntPointer(record.value("duration_seconds")); err != nil {
+			row.Err = errors.New("Invalid duration_seconds")
+		} else if row.Set.Distance, err = parseOptionalFloatPointer(record.value("distance")); err != nil {
+			row.Err = errors.New("Invalid distance")
+		}
+
+		// IDs refer only to the exercises and the workouts of the user, so the exercise is found by its name
+		// and the workout by its key when the row has them, like in the files of the other apps
+		if row.Exercise != "" {
+			row.Set.ExerciseID = 0
+		}
+		if workoutKey := record.value("workout"); workoutKey != "" && row.Err == nil {
+			if _, ok := workouts[workoutKey]; !ok {
+				started, err := parseCSVTime(record.value("workout_started"))
+				if err != nil || started.IsZero() {
+					row.Err = errors.New("Invalid workout_started")
+					rows = append(rows, row)
+					continue
+				}
+				workouts[workoutKey] = &workout{Started: started, ImportKey: workoutKey}
+			}
+			row.Set.WorkoutID = nil
+			row.Workout = workouts[workoutKey]
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// exportSets calls the given function for each set of the user and its workout in the order of creation.
+// The import keys of the sets and the workouts never imported are created with exportKey.
+func exportSets(db *sql.DB, userID string, fn func(set, *workout) error) error {
+	rows, err := db.Query(
+		`SELECT s.id, s.user_id, s.workout_id, s.weight, s.exercise_id, e.name, s.repetitions, s.rpe, s.rir, s.tempo, s.rest_seconds,
+		s.duration_seconds, s.distance, s.set_type, s.notes, s.created, s.modified, COALESCE(s.import_key, ''), w.started, COALESCE(w.import_key, '')
+		FROM sets s JOIN exercises e ON e.id=s.exercise_id LEFT JOIN workouts w ON w.id=s.workout_id
+		WHERE s.user_id=$1 ORDER BY s.created ASC, s.id ASC`,
+		userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s set
+		var workoutStarted *time.Time
+		var workoutKey string
+		err := rows.Scan(&s.ID, &s.UserID, &s.WorkoutID, &s.Weight, &s.ExerciseID, &s.Exercise, &s.Repetitions, &s.RPE, &s.RIR, &s.Tempo,
+			&s.RestSeconds, &s.DurationSeconds, &s.Distance, &s.SetType, &s.Notes, &s.Created, &s.Modified, &s.ImportKey, &workoutStarted, &workoutKey)
+		if err != nil {
+			return err
+		}
+		if s.ImportKey == "" {
+			s.ImportKey = exportKey("set", s.ID, s.Created)
+		}
+
+		var wo *workout
+		if s.WorkoutID != nil {
+			wo = &workout{ID: *s.WorkoutID, Started: *workoutStarted, ImportKey: workoutKey}
+			if wo.ImportKey == "" {
+				wo.ImportKey = exportKey("workout", wo.ID, wo.Started)
+			}
+		}
+		if err := fn(s, wo); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// exportKey returns the import key of a set or a workout created in the application. The key contains
+// the ID and the creation or the start time, so that the set or the workout is found when the export is
+// imported to the same account.
+func exportKey(kind string, id int, t time.Time) string {
+	return fmt.Sprintf("gymlog:%s:%d:%s", kind, id, t.UTC().Format(time.RFC3339Nano))
+}
+
+// parseExportKey returns the ID and the time of a key returned by exportKey. Other keys result in
+// zero values, which match no set or workout.
+func parseExportKey(kind, key string) (int, time.Time) {
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) != 4 || parts[0] != "gymlog" || parts[1] != kind {
+		return 0, time.Time{}
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[3])
+	if err != nil {
+		return 0, time.Time{}
+	}
+	return id, t
+}
+
+// parseCSVTime parses a timestamp of an imported file. Empty value results in zero time.
+func parseCSVTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var err error
+	for _, layout := range csvTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseOptionalInt parses an integer, empty value results in zero
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseOptionalIntPointer parses an integer, empty value results in nil
+func parseOptionalIntPointer(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// parseOptionalFloat parses a decimal number, empty value results in zero
+func parseOptionalFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// parseOptionalFloatPointer parses a decimal number, empty value results in nil
+func parseOptionalFloatPointer(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// formatOptionalInt formats an integer of an exported file, nil results in an empty value
+func formatOptionalInt(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
+
+// formatOptionalFloat formats a decimal number of an exported file, nil results in an empty value
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
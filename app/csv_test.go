@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addSets(userIDs)
+	addSets(userIDs)
+
+	req, _ := http.NewRequest("GET", "/api/v1/export.csv", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	if response.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("Expected content type 'text/csv'. Got '%s'", response.Header().Get("Content-Type"))
+	}
+
+	export := response.Body.Bytes()
+	records, err := csv.NewReader(bytes.NewReader(export)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Header and the two sets of the user
+	if len(records) != 3 {
+		t.Fatalf("Expected '3' rows. Got '%v'", len(records))
+	}
+
+	if records[1][2] != "Squat" {
+		t.Errorf("Expected exercise to be 'Squat'. Got '%s'", records[1][2])
+	}
+
+	// Importing the export again skips the sets, and another account imports them once
+	for _, test := range []struct {
+		username, password   string
+		imported, duplicates int
+	}{
+		{"user1@localhost.com", "password1", 0, 2},
+		{"user2@localhost.com", "password2", 2, 0},
+		{"user2@localhost.com", "password2", 0, 2},
+	} {
+		req, _ := http.NewRequest("POST", "/api/v1/import", bytes.NewBuffer(export))
+		req.AddCookie(authenticate(test.username, test.password))
+		req.Header.Set("Content-Type", "text/csv")
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, response.Code)
+
+		var m importResult
+		json.Unmarshal(response.Body.Bytes(), &m)
+		if m.Imported != test.imported || m.Duplicates != test.duplicates {
+			t.Errorf("Expected '%v' imported and '%v' duplicate sets for '%s'. Got '%+v'", test.imported, test.duplicates, test.username, m)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Exercises are found by their names and aliases
+	var csvStr1 = []byte("created,exercise,weight,repetitions\n2021-01-01 10:00:00,squat,100,5\n2021-01-01T10:05:00Z,BP,80,8\n")
+	req, _ := http.NewRequest("POST", "/api/v1/import", bytes.NewBuffer(csvStr1))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "text/csv")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var m importResult
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m.Imported != 2 {
+		t.Errorf("Expected '2' imported sets. Got '%v'", m.Imported)
+	}
+
+	// Invalid rows are reported and nothing is imported
+	var csvStr2 = []byte("exercise,weight,repetitions\nsquat,100,5\nunknown,100,5\nsquat,heavy,5\n")
+	req, _ = http.NewRequest("POST", "/api/v1/import", bytes.NewBuffer(csvStr2))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "text/csv")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if len(m.Errors) != 2 || m.Errors[0].Row != 3 || m.Errors[1].Row != 4 {
+		t.Errorf("Expected errors on rows '3' and '4'. Got '%+v'", m.Errors)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/v1/sets?total=true", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+
+	var n sets
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Total == nil || *n.Total != 2 {
+		t.Errorf("Expected the user to have '2' sets. Got '%v'", n.Total)
+	}
+
+	// Missing columns
+	var csvStr3 = []byte("exercise,weight\nsquat,100\n")
+	req, _ = http.NewRequest("POST", "/api/v1/import", bytes.NewBuffer(csvStr3))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "text/csv")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
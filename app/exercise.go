@@ -0,0 +1,361 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// exercise is either a built-in exercise shared by all users (UserID is nil)
+// or a custom exercise created by a single user
+type exercise struct {
+	ID          int       `json:"id"`
+	UserID      *string   `json:"userId"`
+	Name        string    `json:"name" validate:"required,max=100"`
+	MuscleGroup string    `json:"muscleGroup" validate:"omitempty,oneof=chest back shoulders biceps triceps forearms core quadriceps hamstrings glutes calves full_body other"`
+	Equipment   string    `json:"equipment" validate:"omitempty,oneof=barbell dumbbell kettlebell machine cable bodyweight band other"`
+	Category    string    `json:"category" validate:"omitempty,oneof=strength cardio plyometrics stretching other"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+}
+
+type exercises struct {
+	Results   int        `json:"results"`
+	Exercises []exercise `json:"exercises"`
+}
+
+func (s *Server) handleGetExercise() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid exercise ID")
+			return
+		}
+
+		exercise := exercise{ID: id}
+		if err := exercise.getExercise(s.DB, claims.UserID); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusNotFound, "Exercise not found")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, exercise)
+	}
+}
+
+func (s *Server) handleGetExercises() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var exercise exercise
+		result, err := exercise.getExercises(s.DB, claims.UserID, r.FormValue("q"))
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, exercises{Results: len(result), Exercises: result})
+	}
+}
+
+func (s *Server) handleCreateExercise() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var exercise exercise
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&exercise); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate exercise
+		err = s.Validator.Struct(exercise)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Check if the name is already taken
+		exists, err := exercise.checkIfExerciseNameExists(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if exists {
+			respondWithError(w, http.StatusBadRequest, "Exercise already exists")
+			return
+		}
+
+		if err := exercise.createExercise(s.DB, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, exercise)
+	}
+}
+
+func (s *Server) handleUpdateExercise() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid exercise ID")
+			return
+		}
+
+		var exercise exercise
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&exercise); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate exercise
+		err = s.Validator.Struct(exercise)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Check if the name is already taken by another exercise
+		exercise.ID = id
+		exists, err := exercise.checkIfExerciseNameExists(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if exists {
+			respondWithError(w, http.StatusBadRequest, "Exercise already exists")
+			return
+		}
+
+		// Built-in exercises can't be modified, so they are not found
+		affectedRows, err := exercise.updateExercise(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+
+		exercise.UserID = &claims.UserID
+		respondWithJSON(w, http.StatusOK, exercise)
+	}
+}
+
+func (s *Server) handleDeleteExercise() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid exercise ID")
+			return
+		}
+
+		// Exercises referred to by sets can't be deleted
+		exercise := exercise{ID: id}
+		inUse, err := exercise.checkIfExerciseInUse(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if inUse {
+			respondWithError(w, http.StatusBadRequest, "Exercise is in use")
+			return
+		}
+
+		affectedRows, err := exercise.deleteExercise(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// getExercise returns a built-in exercise or a custom exercise of the user
+func (e *exercise) getExercise(db queryer, userID string) error {
+	return db.QueryRow("SELECT user_id, name, muscle_group, equipment, category, created, modified FROM exercises WHERE id=$1 AND (user_id IS NULL OR user_id=$2)",
+		e.ID, userID).Scan(&e.UserID, &e.Name, &e.MuscleGroup, &e.Equipment, &e.Category, &e.Created, &e.Modified)
+}
+
+// getExerciseByName finds a built-in exercise or a custom exercise of the user by its name or
+// by an alias of a built-in exercise. Names are compared case-insensitively.
+func (e *exercise) getExerciseByName(db queryer, userID string) error {
+	return db.QueryRow(
+		`SELECT id, user_id, name, muscle_group, equipment, category, created, modified FROM (
+			SELECT 1 AS priority, id, user_id, name, muscle_group, equipment, category, created, modified FROM exercises
+			WHERE (user_id IS NULL OR user_id=$1) AND LOWER(name)=LOWER(TRIM($2))
+			UNION ALL
+			SELECT 2 AS priority, e.id, e.user_id, e.name, e.muscle_group, e.equipment, e.category, e.created, e.modified FROM exercise_aliases a JOIN exercises e ON e.id=a.exercise_id
+			WHERE a.alias=LOWER(TRIM($2))
+		) AS matches ORDER BY priority LIMIT 1`,
+		userID, e.Name).Scan(&e.ID, &e.UserID, &e.Name, &e.MuscleGroup, &e.Equipment, &e.Category, &e.Created, &e.Modified)
+}
+
+// getExercises returns the built-in exercises and the custom exercises of the user,
+// optionally filtered by a part of the name
+func (e *exercise) getExercises(db *sql.DB, userID, query string) ([]exercise, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, name, muscle_group, equipment, category, created, modified FROM exercises WHERE (user_id IS NULL OR user_id=$1) AND name ILIKE '%' || $2 || '%' ORDER BY name ASC",
+		userID, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []exercise{}
+	for rows.Next() {
+		var e exercise
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Name, &e.MuscleGroup, &e.Equipment, &e.Category, &e.Created, &e.Modified); err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, e)
+	}
+
+	return exercises, nil
+}
+
+func (e *exercise) updateExercise(db *sql.DB, userID string) (int64, error) {
+	result, err :=
+		db.Exec("UPDATE exercises SET name=$3, muscle_group=$4, equipment=$5, category=$6, modified=$7 WHERE id=$1 AND user_id=$2",
+			e.ID, userID, e.Name, e.MuscleGroup, e.Equipment, e.Category, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, err
+}
+
+func (e *exercise) deleteExercise(db *sql.DB, userID string) (int64, error) {
+	result, err := db.Exec("DELETE FROM exercises WHERE id=$1 and user_id=$2", e.ID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, err
+}
+
+func (e *exercise) createExercise(db queryer, userID string) error {
+	current := time.Now()
+	err := db.QueryRow(
+		"INSERT INTO exercises(user_id, name, muscle_group, equipment, category, created, modified) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id, user_id, created, modified",
+		userID, e.Name, e.MuscleGroup, e.Equipment, e.Category, current, current).Scan(&e.ID, &e.UserID, &e.Created, &e.Modified)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkIfExerciseNameExists checks if another built-in or custom exercise of the user has the same name
+func (e *exercise) checkIfExerciseNameExists(db *sql.DB, userID string) (bool, error) {
+	count := 0
+	err := db.QueryRow("SELECT COUNT(id) FROM exercises WHERE (user_id IS NULL OR user_id=$1) AND LOWER(name)=LOWER($2) AND id<>$3",
+		userID, e.Name, e.ID).Scan(&count)
+	if err != nil {
+		return true, err
+	}
+
+	return count > 0, nil
+}
+
+func (e *exercise) checkIfExerciseInUse(db *sql.DB, userID string) (bool, error) {
+	count := 0
+	err := db.QueryRow("SELECT COUNT(id) FROM sets WHERE exercise_id=$1 AND user_id=$2", e.ID, userID).Scan(&count)
+	if err != nil {
+		return true, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGetExercises(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	req, _ := http.NewRequest("GET", "/api/v1/exercises?q=squat", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m exercises
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m.Results != 1 || m.Exercises[0].Name != "Squat" {
+		t.Errorf("Expected to find only the built-in 'Squat'. Got '%v'", m.Exercises)
+	}
+
+	if m.Exercises[0].UserID != nil {
+		t.Errorf("Expected the built-in exercise not to have a user. Got '%v'", *m.Exercises[0].UserID)
+	}
+}
+
+func TestCreateExercise(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Valid request
+	var jsonStr1 = []byte(`{"name": "Zercher squat", "muscleGroup": "quadriceps", "equipment": "barbell", "category": "strength"}`)
+	req, _ := http.NewRequest("POST", "/api/v1/exercises", bytes.NewBuffer(jsonStr1))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var m exercise
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	// Custom exercise is visible to its owner
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/v1/exercises/%d", m.ID), nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// Custom exercise is not visible to other users
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/v1/exercises/%d", m.ID), nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// Name of a built-in exercise
+	var jsonStr2 = []byte(`{"name": "squat"}`)
+	req, _ = http.NewRequest("POST", "/api/v1/exercises", bytes.NewBuffer(jsonStr2))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// Invalid muscle group
+	var jsonStr3 = []byte(`{"name": "Sissy squat", "muscleGroup": "toes"}`)
+	req, _ = http.NewRequest("POST", "/api/v1/exercises", bytes.NewBuffer(jsonStr3))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestUpdateBuiltInExercise(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	var jsonStr = []byte(`{"name": "Squat"}`)
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/exercises/%d", getExerciseID("Squat")), bytes.NewBuffer(jsonStr))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestDeleteExerciseInUse(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	var jsonStr1 = []byte(`{"name": "Zercher squat"}`)
+	req, _ := http.NewRequest("POST", "/api/v1/exercises", bytes.NewBuffer(jsonStr1))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var m exercise
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	var jsonStr2 = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, m.ID))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr2))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	// Exercise is referred to by a set
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/v1/exercises/%d", m.ID), nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// Set of other user can't refer to the exercise
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr2))
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
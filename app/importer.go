@@ -0,0 +1,484 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportSize is the maximum size of an imported file in bytes
+const maxImportSize = 10 << 20
+
+// importParser parses the sets of an imported file
+type importParser func(io.Reader) ([]importRow, error)
+
+// importFormats maps the supported formats of imported files to their parsers
+var importFormats = map[string]importParser{
+	"gymlog": parseGymlogCSV,
+	"strong": parseStrongCSV,
+	"hevy":   parseHevyCSV,
+}
+
+// importRow is a set parsed from a row of an imported file
+type importRow struct {
+	Row int
+	Set set
+	// Exercise is the name of the exercise, used when the row has no exercise ID
+	Exercise string
+	// Workout is shared by the rows of the same workout, nil if the row doesn't belong to a workout
+	Workout *workout
+	// Err is the reason the row couldn't be parsed
+	Err error
+}
+
+// importOptions control how the rows of an imported file are saved
+type importOptions struct {
+	// DryRun reports what would be imported without saving anything
+	DryRun bool
+	// CreateExercises creates custom exercises for names not found in the catalog
+	CreateExercises bool
+}
+
+type importError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type importResult struct {
+	Result     string        `json:"result"`
+	DryRun     bool          `json:"dryRun"`
+	Imported   int           `json:"imported"`
+	Duplicates int           `json:"duplicates"`
+	Workouts   int           `json:"workouts"`
+	Exercises  []string      `json:"exercises"`
+	Errors     []importError `json:"errors"`
+}
+
+func (s *Server) handleImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		// Options are read from the URL, because the body contains the file
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "gymlog"
+		}
+		parse, ok := importFormats[format]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Unknown format")
+			return
+		}
+		options := importOptions{
+			DryRun:          query.Get("dryRun") == "true",
+			CreateExercises: query.Get("createExercises") == "true",
+		}
+
+		file, err := readImportFile(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid file")
+			return
+		}
+		defer file.Close()
+
+		rows, err := parse(file)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := s.importRows(claims.UserID, rows, options)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		switch {
+		case len(result.Errors) > 0:
+			respondWithJSON(w, http.StatusBadRequest, result)
+		case options.DryRun:
+			respondWithJSON(w, http.StatusOK, result)
+		default:
+			respondWithJSON(w, http.StatusCreated, result)
+		}
+	}
+}
+
+// readImportFile returns the imported file either from the field "file" of a multipart form or from the request body
+func readImportFile(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	return r.Body, nil
+}
+
+// importRows creates the sets and the workouts of the rows in one transaction. Rows imported earlier are
+// skipped as duplicates. If any of the rows is invalid, nothing is imported and the errors of all the
+// invalid rows are returned. A dry run does everything the same way, but rolls back the transaction.
+func (s *Server) importRows(userID string, rows []importRow, options importOptions) (importResult, error) {
+	result := importResult{DryRun: options.DryRun, Exercises: []string{}, Errors: []importError{}}
+	if len(rows) == 0 {
+		result.Errors = append(result.Errors, importError{Row: 1, Error: "No rows to import"})
+		return result, nil
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// Weights are in the unit of each row or in the unit of the user
+	unit, err := getWeightUnit(tx, userID)
+	if err != nil {
+		return result, err
+	}
+
+	exerciseIDs := map[string]int{}
+	workoutIDs := map[*workout]int{}
+	for _, row := range rows {
+		if row.Err != nil {
+			result.Errors = append(result.Errors, importError{Row: row.Row, Error: row.Err.Error()})
+			continue
+		}
+
+		set := row.Set
+		if set.ExerciseID == 0 {
+			name := strings.ToLower(row.Exercise)
+			if _, ok := exerciseIDs[name]; !ok {
+				exercise, created, err := findImportedExercise(tx, userID, row.Exercise, options.CreateExercises)
+				if err == sql.ErrNoRows {
+					result.Errors = append(result.Errors, importError{Row: row.Row, Error: fmt.Sprintf("Exercise not found: %s", row.Exercise)})
+					continue
+				}
+				if err != nil {
+					return result, err
+				}
+				if created {
+					result.Exercises = append(result.Exercises, exercise.Name)
+				}
+				exerciseIDs[name] = exercise.ID
+			}
+			set.ExerciseID = exerciseIDs[name]
+		}
+
+		if err := s.Validator.Struct(set); err != nil {
+			result.Errors = append(result.Errors, importError{Row: row.Row, Error: err.Error()})
+			continue
+		}
+
+		if set.ImportKey != "" {
+			imported, err := set.checkIfImported(tx, userID)
+			if err != nil {
+				return result, err
+			}
+			if imported {
+				result.Duplicates++
+				continue
+			}
+		}
+
+		// Workouts imported earlier are reused, so that the new sets of a workout join the old ones
+		if row.Workout != nil {
+			if _, ok := workoutIDs[row.Workout]; !ok {
+				workout := *row.Workout
+				err := workout.getImportedWorkout(tx, userID)
+				if err == sql.ErrNoRows {
+					err = workout.createWorkout(tx, userID)
+					result.Workouts++
+				}
+				if err != nil {
+					return result, err
+				}
+				workoutIDs[row.Workout] = workout.ID
+			}
+			workoutID := workoutIDs[row.Workout]
+			set.WorkoutID = &workoutID
+		}
+
+		if err := set.checkReferences(tx, userID); err != nil {
+			if err == errExerciseNotFound || err == errWorkoutNotFound {
+				result.Errors = append(result.Errors, importError{Row: row.Row, Error: err.Error()})
+				continue
+			}
+			return result, err
+		}
+
+		set.toKilograms(unit)
+		if err := set.createImportedSet(tx, userID); err != nil {
+			return result, err
+		}
+		result.Imported++
+	}
+
+	switch {
+	case options.DryRun:
+		result.Result = "dry run"
+		return result, nil
+	case len(result.Errors) > 0:
+		result.Result = "rolled back"
+		result.Imported, result.Duplicates, result.Workouts, result.Exercises = 0, 0, 0, []string{}
+		return result, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, err
+	}
+	result.Result = "success"
+	return result, nil
+}
+
+// equipmentSuffix matches the equipment other apps add to the names of exercises, e.g. "Bench Press (Barbell)"
+var equipmentSuffix = regexp.MustCompile(`\s*\([^)]*\)\s*$`)
+
+// findImportedExercise finds the exercise of an imported row by its name. Names are tried with and without
+// the equipment suffix. If the exercise is not found, it's optionally created as a custom exercise.
+func findImportedExercise(db queryer, userID, name string, create bool) (exercise, bool, error) {
+	for _, candidate := range []string{name, equipmentSuffix.ReplaceAllString(name, "")} {
+		exercise := exercise{Name: candidate}
+		err := exercise.getExerciseByName(db, userID)
+		if err == nil {
+			return exercise, false, nil
+		}
+		if err != sql.ErrNoRows {
+			return exercise, false, err
+		}
+	}
+
+	exercise := exercise{Name: strings.TrimSpace(name)}
+	if !create || exercise.Name == "" {
+		return exercise, false, sql.ErrNoRows
+	}
+	if err := exercise.createExercise(db, userID); err != nil {
+		return exercise, false, err
+	}
+	return exercise, true, nil
+}
+
+// parseStrongCSV parses the sets of a workout export of the Strong app
+func parseStrongCSV(file io.Reader) ([]importRow, error) {
+	records, err := readCSV(file, "date", "workout name", "exercise name", "set order", "weight", "reps")
+	if err != nil {
+		return nil, err
+	}
+
+	workouts := map[string]*workout{}
+	keys := importKeys{}
+	var rows []importRow
+	for _, record := range records {
+		row := importRow{Row: record.Row, Exercise: record.value("exercise name"), Err: record.Err}
+		row.Set.Unit = strings.ToLower(strings.TrimSuffix(record.value("weight unit"), "s"))
+		if row.Err != nil {
+			rows = append(rows, row)
+			continue
+		}
+
+		// Rest timers are exported as rows of their own
+		if strings.EqualFold(record.value("set order"), "rest timer") {
+			continue
+		}
+
+		started, err := parseCSVTime(record.value("date"))
+		if err != nil || started.IsZero() {
+			row.Err = errors.New("Invalid date")
+			rows = append(rows, row)
+			continue
+		}
+
+		workoutKey := fmt.Sprintf("strong:%s:%s", started.Format(time.RFC3339), record.value("workout name"))
+		if _, ok := workouts[workoutKey]; !ok {
+			workout := &workout{Started: started, Notes: joinNotes(record.value("workout name"), record.value("workout notes")), ImportKey: workoutKey}
+			if duration, err := parseStrongDuration(record.value("duration")); err == nil && duration > 0 {
+				ended := started.Add(duration)
+				workout.Ended = &ended
+			}
+			workouts[workoutKey] = workout
+		}
+		row.Workout = workouts[workoutKey]
+
+		row.Set.Created = started
+		row.Set.ImportKey = keys.next(fmt.Sprintf("%s:%s:%s", workoutKey, row.Exercise, record.value("set order")))
+		if row.Exercise == "" {
+			row.Err = errors.New("Missing exercise name")
+		} else if row.Set.Weight, err = parseOptionalFloat(record.value("weight")); err != nil {
+			row.Err = errors.New("Invalid weight")
+		} else if row.Set.Repetitions, err = parseOptionalInt(strings.TrimSuffix(record.value("reps"), ".0")); err != nil {
+			row.Err = errors.New("Invalid reps")
+		} else if row.Set.RPE, err = parseImportedAmount(record.value("rpe")); err != nil {
+			row.Err = errors.New("Invalid RPE")
+		} else if seconds, err := parseImportedAmount(record.value("seconds")); err != nil {
+			row.Err = errors.New("Invalid seconds")
+		} else if seconds != nil {
+			duration := int(*seconds)
+			row.Set.DurationSeconds = &duration
+		}
+		row.Set.SetType = strongSetTypes[strings.ToUpper(record.value("set order"))]
+		row.Set.Notes = record.value("notes")
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseHevyCSV parses the sets of a workout export of the Hevy app
+func parseHevyCSV(file io.Reader) ([]importRow, error) {
+	records, err := readCSV(file, "title", "start_time", "exercise_title", "set_index", "reps")
+	if err != nil {
+		return nil, err
+	}
+
+	workouts := map[string]*workout{}
+	keys := importKeys{}
+	var rows []importRow
+	for _, record := range records {
+		row := importRow{Row: record.Row, Exercise: record.value("exercise_title"), Err: record.Err}
+		// Hevy names the weight column by the unit of the user
+		weightColumn := "weight_kg"
+		row.Set.Unit = unitKilograms
+		if _, ok := record.Values["weight_lbs"]; ok {
+			weightColumn = "weight_lbs"
+			row.Set.Unit = unitPounds
+		}
+		if row.Err != nil {
+			rows = append(rows, row)
+			continue
+		}
+
+		started, err := parseCSVTime(record.value("start_time"))
+		if err != nil || started.IsZero() {
+			row.Err = errors.New("Invalid start_time")
+			rows = append(rows, row)
+			continue
+		}
+
+		workoutKey := fmt.Sprintf("hevy:%s:%s", started.Format(time.RFC3339), record.value("title"))
+		if _, ok := workouts[workoutKey]; !ok {
+			workout := &workout{Started: started, Notes: joinNotes(record.value("title"), record.value("description")), ImportKey: workoutKey}
+			if ended, err := parseCSVTime(record.value("end_time")); err == nil && ended.After(started) {
+				workout.Ended = &ended
+			}
+			workouts[workoutKey] = workout
+		}
+		row.Workout = workouts[workoutKey]
+
+		row.Set.Created = started
+		row.Set.ImportKey = keys.next(fmt.Sprintf("%s:%s:%s", workoutKey, row.Exercise, record.value("set_index")))
+		if row.Exercise == "" {
+			row.Err = errors.New("Missing exercise_title")
+		} else if row.Set.Weight, err = parseOptionalFloat(record.value(weightColumn)); err != nil {
+			row.Err = fmt.Errorf("Invalid %s", weightColumn)
+		} else if row.Set.Repetitions, err = parseOptionalInt(record.value("reps")); err != nil {
+			row.Err = errors.New("Invalid reps")
+		} else if row.Set.RPE, err = parseImportedAmount(record.value("rpe")); err != nil {
+			row.Err = errors.New("Invalid rpe")
+		} else if seconds, err := parseImportedAmount(record.value("duration_seconds")); err != nil {
+			row.Err = errors.New("Invalid duration_seconds")
+		} else if distance, err := parseImportedAmount(record.value("distance_km")); err != nil {
+			row.Err = errors.New("Invalid distance_km")
+		} else {
+			if seconds != nil {
+				duration := int(*seconds)
+				row.Set.DurationSeconds = &duration
+			}
+			if distance != nil {
+				meters := *distance * 1000
+				row.Set.Distance = &meters
+			}
+		}
+		row.Set.SetType = hevySetTypes[record.value("set_type")]
+		row.Set.Notes = record.value("exercise_notes")
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// strongSetTypes maps the set orders Strong uses for special sets to set types. Numbered sets are working sets.
+var strongSetTypes = map[string]string{
+	"W": "warmup",
+	"D": "drop",
+	"F": "failure",
+}
+
+// hevySetTypes maps the set types of Hevy to set types
+var hevySetTypes = map[string]string{
+	"normal":  "working",
+	"warmup":  "warmup",
+	"dropset": "drop",
+	"failure": "failure",
+}
+
+// parseImportedAmount parses an optional amount of an imported file. Other apps export missing
+// amounts as zero, so both empty and zero values result in nil.
+func parseImportedAmount(value string) (*float64, error) {
+	f, err := parseOptionalFloatPointer(value)
+	if err != nil || f == nil || *f == 0 {
+		return nil, err
+	}
+	return f, nil
+}
+
+// importKeys makes the import keys of a file unique by numbering keys that occur several times,
+// e.g. when the same exercise is done twice in a workout
+type importKeys map[string]int
+
+func (k importKeys) next(key string) string {
+	k[key]++
+	if k[key] == 1 {
+		return key
+	}
+	return fmt.Sprintf("%s:%d", key, k[key])
+}
+
+// strongDuration matches the durations of Strong workouts, e.g. "1h 5m" or "45m"
+var strongDuration = regexp.MustCompile(`^(?:(\d+)h)?\s*(?:(\d+)m)?\s*(?:(\d+)s)?$`)
+
+// parseStrongDuration parses the duration of a Strong workout
+func parseStrongDuration(value string) (time.Duration, error) {
+	matches := strongDuration.FindStringSubmatch(value)
+	if value == "" || matches == nil {
+		return 0, errors.New("invalid duration")
+	}
+
+	var duration time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if matches[i+1] != "" {
+			n, _ := strconv.Atoi(matches[i+1])
+			duration += time.Duration(n) * unit
+		}
+	}
+	return duration, nil
+}
+
+// joinNotes joins the non-empty parts of notes to separate lines
+func joinNotes(parts ...string) string {
+	var notes []string
+	for _, part := range parts {
+		if part != "" {
+			notes = append(notes, part)
+		}
+	}
+	return strings.Join(notes, "\n")
+}
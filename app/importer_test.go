@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+var strongCSV = []byte(`Date,Workout Name,Duration,Exercise Name,Set Order,Weight,Reps,Distance,Seconds,Notes,Workout Notes,RPE
+2021-01-05 18:30:12,Leg day,1h 5m,Squat (Barbell),1,100.0,5.0,0,0,,,
+2021-01-05 18:30:12,Leg day,1h 5m,Squat (Barbell),Rest Timer,0,0,0,90,,,
+2021-01-05 18:30:12,Leg day,1h 5m,Squat (Barbell),2,105.0,5.0,0,0,,,
+2021-01-05 18:30:12,Leg day,1h 5m,Leg Curl (Machine),1,40.0,12.0,0,0,,,
+`)
+
+func TestImportStrong(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Unknown exercises are reported unless they are created
+	m := importFile(t, "format=strong", strongCSV, http.StatusBadRequest)
+	if len(m.Errors) != 1 || m.Errors[0].Row != 5 {
+		t.Errorf("Expected an error on row '5'. Got '%+v'", m.Errors)
+	}
+
+	// Dry run reports the result without saving anything
+	m = importFile(t, "format=strong&createExercises=true&dryRun=true", strongCSV, http.StatusOK)
+	if m.Result != "dry run" || m.Imported != 3 || m.Workouts != 1 || len(m.Exercises) != 1 {
+		t.Errorf("Expected a dry run of '3' sets, '1' workout and '1' exercise. Got '%+v'", m)
+	}
+
+	m = importFile(t, "format=strong&createExercises=true", strongCSV, http.StatusCreated)
+	if m.Imported != 3 || m.Workouts != 1 || len(m.Exercises) != 1 || m.Exercises[0] != "Leg Curl (Machine)" {
+		t.Errorf("Expected '3' sets, '1' workout and exercise 'Leg Curl (Machine)'. Got '%+v'", m)
+	}
+
+	// Importing the same file again skips the sets imported earlier
+	m = importFile(t, "format=strong", strongCSV, http.StatusCreated)
+	if m.Imported != 0 || m.Duplicates != 3 || m.Workouts != 0 {
+		t.Errorf("Expected '3' duplicates. Got '%+v'", m)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/workouts", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+
+	var n workouts
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Results != 1 || n.Workouts[0].Ended == nil || n.Workouts[0].Ended.Sub(n.Workouts[0].Started).Minutes() != 65 {
+		t.Errorf("Expected '1' workout lasting '65' minutes. Got '%+v'", n.Workouts)
+	}
+}
+
+func TestImportStrongBodyweight(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Strong exports bodyweight sets with zero or empty weight
+	var bodyweightCSV = []byte(`Date,Workout Name,Duration,Exercise Name,Set Order,Weight,Reps,Distance,Seconds,Notes,Workout Notes,RPE
+2021-01-07 18:30:12,Pull day,45m,Pull Up,1,0,10.0,0,0,,,
+2021-01-07 18:30:12,Pull day,45m,Pull Up,2,,8.0,0,0,,,
+`)
+	m := importFile(t, "format=strong&createExercises=true", bodyweightCSV, http.StatusCreated)
+	if m.Imported != 2 || len(m.Errors) != 0 {
+		t.Errorf("Expected '2' bodyweight sets. Got '%+v'", m)
+	}
+}
+
+func TestImportHevy(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	var hevyCSV = []byte(`"title","start_time","end_time","description","exercise_title","superset_id","exercise_notes","set_index","set_type","weight_kg","reps","distance_km","duration_seconds","rpe"
+"Push","5 Jan 2021, 18:30","5 Jan 2021, 19:20","","Bench Press (Barbell)",,"",0,"normal",80,8,,,
+"Push","5 Jan 2021, 18:30","5 Jan 2021, 19:20","","Bench Press (Barbell)",,"",1,"normal",80,7,,,
+`)
+	m := importFile(t, "format=hevy", hevyCSV, http.StatusCreated)
+	if m.Imported != 2 || m.Workouts != 1 {
+		t.Errorf("Expected '2' sets and '1' workout. Got '%+v'", m)
+	}
+}
+
+func importFile(t *testing.T, query string, file []byte, expectedCode int) importResult {
+	req, _ := http.NewRequest("POST", "/api/v1/import?"+query, bytes.NewBuffer(file))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "text/csv")
+	response := executeRequest(req)
+	checkResponseCode(t, expectedCode, response.Code)
+
+	var m importResult
+	json.Unmarshal(response.Body.Bytes(), &m)
+	return m
+}
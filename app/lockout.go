@@ -0,0 +1,154 @@
+package app
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// loginFailureWindow is the time without failed logins after which the failures are forgotten
+	loginFailureWindow = 24 * time.Hour
+	// maxLockout is the longest time a username or an IP address is locked out
+	maxLockout = 24 * time.Hour
+	// emailRequestMaxRequests is the number of requests for emails per username and emailRequestMaxIPRequests per
+	// IP address after which requesting is locked out for emailRequestLockout, which doubles with each further request
+	emailRequestMaxRequests   = 3
+	emailRequestMaxIPRequests = 20
+	emailRequestLockout       = time.Hour
+)
+
+// dummyPasswordHash is compared with the password when the user doesn't exist, so that logging in takes
+// as long whether the user exists or not
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), passwordHashCost)
+
+// loginFailureKeys returns the keys under which the failed logins of the username and the IP address are counted.
+// Failures are counted per username instead of per user, so that unknown usernames are locked out as well.
+func loginFailureKeys(username, ip string) (string, string) {
+	return "user:" + strings.ToLower(username), "ip:" + ip
+}
+
+// clientIP returns the IP address of the client. X-Forwarded-For is used only when the application is
+// configured to be behind a proxy, and then the address added by the proxy is used.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getLoginLockout returns the time until which logging in with either of the keys is locked, or nil if it isn't locked
+func getLoginLockout(db queryer, accountKey, ipKey string) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow("SELECT MAX(locked_until) FROM login_failures WHERE key IN ($1, $2) AND locked_until > $3",
+		accountKey, ipKey, time.Now()).Scan(&lockedUntil)
+	if err != nil || !lockedUntil.Valid {
+		return nil, err
+	}
+	return &lockedUntil.Time, nil
+}
+
+// respondWithLockout tells the client when logging in can be tried again
+func respondWithLockout(w http.ResponseWriter, lockedUntil time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts")
+}
+
+// recordLoginFailures counts a failed login for both the username and the IP address. More failures are
+// allowed per IP address, since many users may log in from behind the same address.
+func (s *Server) recordLoginFailures(db queryer, accountKey, ipKey string) error {
+	if err := recordLoginFailure(db, accountKey, s.LoginMaxFailures, s.LoginLockout); err != nil {
+		return err
+	}
+	return recordLoginFailure(db, ipKey, s.LoginMaxIPFailures, s.LoginLockout)
+}
+
+// recordLoginFailure counts a failed login for the key. When the failures reach the maximum, the key is locked
+// out for the base lockout, which doubles with each further failure. The count is kept in the database, so that
+// all the instances of the application share it.
+func recordLoginFailure(db queryer, key string, maxFailures int, lockout time.Duration) error {
+	current := time.Now()
+	var failures int
+	err := db.QueryRow(
+		`INSERT INTO login_failures(key, failures, last_failure) VALUES($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure < $3 THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure = $2
+		RETURNING failures`,
+		key, current, current.Add(-loginFailureWindow)).Scan(&failures)
+	if err != nil || failures < maxFailures {
+		return err
+	}
+
+	for i := maxFailures; i < failures && lockout < maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockout {
+		lockout = maxLockout
+	}
+	_, err = db.Exec("UPDATE login_failures SET locked_until=$2 WHERE key=$1", key, current.Add(lockout))
+	return err
+}
+
+// clearLoginFailures forgets the failed logins of the key after a successful login
+func clearLoginFailures(db queryer, key string) error {
+	_, err := db.Exec("DELETE FROM login_failures WHERE key=$1", key)
+	return err
+}
+
+// deleteExpiredLoginFailures removes the failures that have been forgotten and aren't locking out anymore
+func deleteExpiredLoginFailures(db queryer) error {
+	current := time.Now()
+	_, err := db.Exec("DELETE FROM login_failures WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < $2)",
+		current.Add(-loginFailureWindow), current)
+	return err
+}
+
+// emailRequestKeys returns the keys under which the requests for emails of the kind, e.g. "reset", are counted for
+// the username and the IP address. They are kept apart from the keys of failed logins, so that requesting emails
+// doesn't lock out logging in.
+func emailRequestKeys(kind, username, ip string) (string, string) {
+	return kind + ":user:" + strings.ToLower(username), kind + ":ip:" + ip
+}
+
+// limitEmailRequests counts a request for an email of the kind like a failed login. It responds and returns false
+// if the username or the IP address has requested too many emails.
+func (s *Server) limitEmailRequests(w http.ResponseWriter, r *http.Request, kind, username string) bool {
+	accountKey, ipKey := emailRequestKeys(kind, username, s.clientIP(r))
+	lockedUntil, err := getLoginLockout(s.DB, accountKey, ipKey)
+	if err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if lockedUntil != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*lockedUntil).Seconds())+1))
+		respondWithError(w, http.StatusTooManyRequests, "Too many requests")
+		return false
+	}
+	if err := recordLoginFailure(s.DB, accountKey, emailRequestMaxRequests, emailRequestLockout); err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	if err := recordLoginFailure(s.DB, ipKey, emailRequestMaxIPRequests, emailRequestLockout); err != nil {
+		log.Println(err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer sends emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// smtpMailer sends emails through an SMTP server
+type smtpMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	message := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", m.host, m.port), auth, m.from, []string{to}, []byte(message))
+}
+
+// logMailer writes emails to a file or, if no file is given, to the log instead of sending them.
+// It's meant for local development.
+type logMailer struct {
+	file string
+}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	message := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, subject, body)
+	if m.file == "" {
+		log.Printf("Email not sent:\n%s", message)
+		return nil
+	}
+
+	f, err := os.OpenFile(m.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(message + "\n")
+	return err
+}
+
+// newMailer returns an SMTP mailer if SMTP_HOST is set and a log mailer otherwise
+func newMailer() Mailer {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		return &smtpMailer{
+			host:     host,
+			port:     getEnvInt("SMTP_PORT", 587),
+			username: os.Getenv("SMTP_USERNAME"),
+			password: os.Getenv("SMTP_PASSWORD"),
+			from:     os.Getenv("SMTP_FROM"),
+		}
+	}
+	return &logMailer{file: os.Getenv("MAIL_FILE")}
+}
@@ -0,0 +1,467 @@
+package app
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oidcStateTTL is the time the user has for logging in at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie is the cookie holding the state in the browser that started the login
+const oidcStateCookie = "oidc_state"
+
+// oidcState is a login started at an identity provider. The state is sent to the provider and back, and only
+// its hash is stored. UserID is set when a logged in user links an identity to their account.
+type oidcState struct {
+	Hash         string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       sql.NullString
+	Expires      time.Time
+	Used         bool
+	Created      time.Time
+}
+
+// identity is an account of an identity provider linked to a user
+type identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   string    `json:"-"`
+	Email    string    `json:"email"`
+	Created  time.Time `json:"created"`
+}
+
+type identities struct {
+	Results    int        `json:"results"`
+	Identities []identity `json:"identities"`
+}
+
+// handleOIDCLogin sends the user to log in at the identity provider. With the parameter link=true,
+// a logged in user links the identity of the provider to their account instead.
+func (s *Server) handleOIDCLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.OIDCProviders[mux.Vars(r)["provider"]]
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Unknown identity provider")
+			return
+		}
+
+		state := oidcState{Provider: provider.Name}
+		if r.FormValue("link") == "true" {
+			claims, err := readClaims(r)
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			revoked, err := checkIfTokenRevoked(s.DB, claims)
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if revoked {
+				respondWithError(w, http.StatusUnauthorized, "Token revoked")
+				return
+			}
+			state.UserID = sql.NullString{String: claims.UserID, Valid: true}
+		}
+
+		stateValue, err := newRandomToken()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if state.Nonce, err = newRandomToken(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if state.CodeVerifier, err = newRandomToken(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		authorizationURL, err := provider.authorizationURL(stateValue, state.Nonce, state.CodeVerifier)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadGateway, "Identity provider not available")
+			return
+		}
+
+		current := time.Now()
+		state.Hash = hashToken(stateValue)
+		state.Expires = current.Add(oidcStateTTL)
+		state.Created = current
+		if err := state.createOIDCState(s.DB); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The state is bound to the browser, so that nobody can finish their own login in someone else's browser
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    stateValue,
+			Expires:  state.Expires,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/api/users/oidc",
+		})
+		http.Redirect(w, r, authorizationURL, http.StatusFound)
+	}
+}
+
+// handleOIDCCallback finishes the login at the identity provider. The identity is linked to the user who
+// started linking it, to the user with the same email address if the provider has verified the address,
+// or to a new user created on the first login.
+func (s *Server) handleOIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := s.OIDCProviders[mux.Vars(r)["provider"]]
+		if !ok {
+			respondWithError(w, http.StatusNotFound, "Unknown identity provider")
+			return
+		}
+		if providerError := r.FormValue("error"); providerError != "" {
+			log.Printf("Login with %s failed: %s", provider.Name, providerError)
+			respondWithError(w, http.StatusUnauthorized, "Login failed at the identity provider")
+			return
+		}
+		if r.FormValue("state") == "" || r.FormValue("code") == "" {
+			respondWithError(w, http.StatusBadRequest, "Missing state or code")
+			return
+		}
+
+		// The callback must come to the browser that started the login
+		stateCookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(r.FormValue("state"))) != 1 {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired state")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/api/users/oidc",
+		})
+
+		// The state is used before calling the provider, so that the same callback can't be replayed meanwhile
+		state, err := useOIDCState(s.DB, r.FormValue("state"))
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if state == nil || state.Provider != provider.Name {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired state")
+			return
+		}
+
+		rawIDToken, err := provider.exchange(r.FormValue("code"), state.CodeVerifier)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusUnauthorized, "Login failed at the identity provider")
+			return
+		}
+		oidcIdentity, err := provider.verifyIDToken(rawIDToken, state.Nonce)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusUnauthorized, "Invalid ID token")
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		identity := identity{Provider: provider.Name, Subject: oidcIdentity.Subject, Email: oidcIdentity.Email}
+		err = identity.getIdentity(tx)
+		switch {
+		case err == nil:
+			// The identity has been linked before
+			if state.UserID.Valid && state.UserID.String != identity.UserID {
+				respondWithError(w, http.StatusConflict, "Identity already linked to another account")
+				return
+			}
+		case err != sql.ErrNoRows:
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		case state.UserID.Valid:
+			identity.UserID = state.UserID.String
+		case oidcIdentity.Email == "":
+			respondWithError(w, http.StatusBadRequest, "Identity provider didn't return an email address")
+			return
+		default:
+			// An existing account is linked only if the provider is trusted and both the provider and the
+			// application have verified the address, so that nobody can take over an account by registering
+			// its address elsewhere. Otherwise the user logs in with the password and links the identity.
+			existing := user{Username: oidcIdentity.Email}
+			err := existing.getUserByUsername(tx)
+			switch {
+			case err == nil && provider.Trusted && oidcIdentity.EmailVerified && existing.Verified:
+				identity.UserID = existing.UserID
+			case err == nil:
+				respondWithError(w, http.StatusConflict, "An account with the email address exists, log in with the password to link the identity")
+				return
+			case err != sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			default:
+				if identity.UserID, err = s.createExternalUser(tx, oidcIdentity); err != nil {
+					log.Println(err.Error())
+					respondWithError(w, http.StatusInternalServerError, "Internal server error")
+					return
+				}
+			}
+		}
+		if err == sql.ErrNoRows {
+			if err := identity.createIdentity(tx); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+		}
+
+		// Linking doesn't log in again, the user is logged in already
+		if state.UserID.Valid {
+			if err := tx.Commit(); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			http.Redirect(w, r, s.AppURL, http.StatusFound)
+			return
+		}
+
+		user := user{UserID: identity.UserID}
+		if err := user.getUserByID(tx); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if user.Enabled == 0 {
+			respondWithError(w, http.StatusForbidden, "Account disabled")
+			return
+		}
+		if !user.Verified {
+			// A new user was created, so the transaction is committed and the verification email sent
+			if err := tx.Commit(); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			respondWithError(w, http.StatusForbidden, "Email address not verified")
+			return
+		}
+
+		// Users with two-factor authentication get the tokens only after entering the code
+		var totp totp
+		if err := totp.getTOTP(tx, user.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if totp.Enabled {
+			challenge, err := createLoginChallenge(tx, user.UserID)
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			http.Redirect(w, r, s.AppURL+"/login?challenge="+challenge, http.StatusFound)
+			return
+		}
+
+		familyID, err := uuid.NewRandom()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		tokens, err := s.createTokens(tx, r, user.UserID, user.Username, familyID.String())
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		tokens.setCookies(w)
+		http.Redirect(w, r, s.AppURL, http.StatusFound)
+	}
+}
+
+func (s *Server) handleGetIdentities() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		result, err := getIdentities(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, identities{Results: len(result), Identities: result})
+	}
+}
+
+func (s *Server) handleDeleteIdentity() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		affectedRows, err := deleteIdentities(s.DB, claims.UserID, mux.Vars(r)["provider"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// createExternalUser creates a user for an identity of a provider. Nobody knows the password of the user,
+// who can set it by resetting the password. Addresses not verified by the provider are verified by email.
+func (s *Server) createExternalUser(db queryer, oidcIdentity *oidcIdentity) (string, error) {
+	userID, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	randomPassword, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+
+	user := user{UserID: userID.String(), Username: oidcIdentity.Email, Verified: oidcIdentity.EmailVerified}
+	if err := user.createUser(db, user.UserID, string(hashedPassword)); err != nil {
+		return "", err
+	}
+	if !user.Verified {
+		if err := s.sendVerificationEmail(db, &user); err != nil {
+			log.Println(err.Error())
+		}
+	}
+	return user.UserID, nil
+}
+
+func (st *oidcState) createOIDCState(db queryer) error {
+	_, err := db.Exec(
+		"INSERT INTO oidc_states(state_hash, provider, nonce, code_verifier, user_id, expires, used, created) VALUES($1, $2, $3, $4, $5, $6, false, $7)",
+		st.Hash, st.Provider, st.Nonce, st.CodeVerifier, st.UserID, st.Expires, st.Created)
+	return err
+}
+
+// useOIDCState marks the state used and returns it, or nil if the state is unknown, used or expired
+func useOIDCState(db queryer, value string) (*oidcState, error) {
+	var st oidcState
+	err := db.QueryRow(
+		`UPDATE oidc_states SET used=true WHERE state_hash=$1 AND NOT used AND expires > $2
+		RETURNING state_hash, provider, nonce, code_verifier, user_id, expires, used, created`,
+		hashToken(value), time.Now()).Scan(&st.Hash, &st.Provider, &st.Nonce, &st.CodeVerifier, &st.UserID, &st.Expires, &st.Used, &st.Created)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// deleteExpiredOIDCStates removes the logins that were never finished
+func deleteExpiredOIDCStates(db queryer) error {
+	_, err := db.Exec("DELETE FROM oidc_states WHERE expires < $1", time.Now())
+	return err
+}
+
+func (i *identity) getIdentity(db queryer) error {
+	return db.QueryRow("SELECT user_id, email, created FROM user_identities WHERE provider=$1 AND subject=$2",
+		i.Provider, i.Subject).Scan(&i.UserID, &i.Email, &i.Created)
+}
+
+func (i *identity) createIdentity(db queryer) error {
+	i.Created = time.Now()
+	_, err := db.Exec("INSERT INTO user_identities(provider, subject, user_id, email, created) VALUES($1, $2, $3, $4, $5)",
+		i.Provider, i.Subject, i.UserID, i.Email, i.Created)
+	return err
+}
+
+func getIdentities(db queryer, userID string) ([]identity, error) {
+	rows, err := db.Query("SELECT provider, subject, user_id, email, created FROM user_identities WHERE user_id=$1 ORDER BY provider, created",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []identity{}
+	for rows.Next() {
+		var i identity
+		if err := rows.Scan(&i.Provider, &i.Subject, &i.UserID, &i.Email, &i.Created); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// deleteIdentities unlinks the identities of the provider from the user
+func deleteIdentities(db queryer, userID, provider string) (int64, error) {
+	result, err := db.Exec("DELETE FROM user_identities WHERE user_id=$1 AND provider=$2", userID, provider)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
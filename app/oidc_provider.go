@@ -0,0 +1,364 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// oidcScopes are the scopes asked from identity providers, the email address is used as the username
+const oidcScopes = "openid email"
+
+// oidcKeysRefetchInterval is the shortest time between fetching the signing keys of a provider, so that
+// tokens with unknown key IDs can't make the server fetch the keys on every request
+const oidcKeysRefetchInterval = time.Minute
+
+// oidcProvider is an OpenID Connect identity provider, e.g. Google. The endpoints and the signing keys are
+// fetched from the discovery document of the issuer when they are needed for the first time.
+type oidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the address of the callback endpoint of the provider, as registered to the provider
+	RedirectURL string
+	// Trusted is true when the provider is trusted to verify the email addresses, so that a login links
+	// the identity to an existing account with the same verified address
+	Trusted bool
+
+	client        *http.Client
+	mutex         sync.Mutex
+	configuration *oidcConfiguration
+	keys          map[string]interface{}
+	keysFetched   time.Time
+}
+
+// oidcConfiguration is the part of the discovery document of a provider that is used
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcIdentity is the user as identified by the ID token of a provider
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set. Only RSA and P-256 keys of providers are supported.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// idTokenClaims are the claims of an ID token. Unlike jwt.MapClaims, the expiry is checked with some
+// leeway and the time of issuing isn't checked, since the clocks of the provider and the server differ.
+type idTokenClaims struct {
+	values jwt.MapClaims
+}
+
+// idTokenLeeway is the accepted difference between the clocks of the provider and the server
+const idTokenLeeway = time.Minute
+
+func (c *idTokenClaims) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.values)
+}
+
+// Valid checks that the ID token hasn't expired
+func (c *idTokenClaims) Valid() error {
+	exp, ok := c.values["exp"].(float64)
+	if !ok {
+		return errors.New("ID token has no expiry")
+	}
+	if time.Now().Add(-idTokenLeeway).Unix() > int64(exp) {
+		return errors.New("ID token has expired")
+	}
+	return nil
+}
+
+// newOIDCProviders reads the providers from the environment. OIDC_PROVIDERS lists the names of the providers,
+// and each provider is configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET
+// and OIDC_<NAME>_REDIRECT_URL.
+func newOIDCProviders() map[string]*oidcProvider {
+	providers := map[string]*oidcProvider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := newOIDCProvider(name, os.Getenv(prefix+"ISSUER"), os.Getenv(prefix+"CLIENT_ID"),
+			os.Getenv(prefix+"CLIENT_SECRET"), os.Getenv(prefix+"REDIRECT_URL"))
+		provider.Trusted = os.Getenv(prefix+"TRUSTED") == "true"
+		providers[name] = provider
+	}
+	return providers
+}
+
+func newOIDCProvider(name, issuer, clientID, clientSecret, redirectURL string) *oidcProvider {
+	return &oidcProvider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getConfiguration returns the discovery document of the provider, fetching it on the first call
+func (p *oidcProvider) getConfiguration() (*oidcConfiguration, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.configuration != nil {
+		return p.configuration, nil
+	}
+
+	var configuration oidcConfiguration
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &configuration); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(configuration.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("issuer %s of the discovery document doesn't match %s", configuration.Issuer, p.Issuer)
+	}
+
+	p.configuration = &configuration
+	return p.configuration, nil
+}
+
+// authorizationURL returns the address where the user is sent to log in. The code challenge is derived
+// from the verifier as defined in PKCE (RFC 7636), so that a stolen code is useless without the verifier.
+func (p *oidcProvider) authorizationURL(state, nonce, codeVerifier string) (string, error) {
+	configuration, err := p.getConfiguration()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.ClientID)
+	values.Set("redirect_uri", p.RedirectURL)
+	values.Set("scope", oidcScopes)
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(configuration.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return configuration.AuthorizationEndpoint + separator + values.Encode(), nil
+}
+
+// exchange exchanges the authorization code for the ID token
+func (p *oidcProvider) exchange(code, codeVerifier string) (string, error) {
+	configuration, err := p.getConfiguration()
+	if err != nil {
+		return "", err
+	}
+
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.RedirectURL)
+	values.Set("client_id", p.ClientID)
+	values.Set("code_verifier", codeVerifier)
+	if p.ClientSecret != "" {
+		values.Set("client_secret", p.ClientSecret)
+	}
+
+	response, err := p.client.PostForm(configuration.TokenEndpoint, values)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint of %s responded %d: %s", p.Name, response.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("token endpoint of %s returned no ID token", p.Name)
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken checks the signature, the issuer, the audience, the expiry and the nonce of the ID token
+// and returns the identity in it
+func (p *oidcProvider) verifyIDToken(rawIDToken, nonce string) (*oidcIdentity, error) {
+	tokenClaims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(rawIDToken, tokenClaims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+	claims := tokenClaims.values
+
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", issuer)
+	}
+	if !claimContains(claims["aud"], p.ClientID) {
+		return nil, errors.New("ID token isn't meant for this client")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("unexpected nonce")
+	}
+
+	identity := oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	// Some providers, e.g. Apple, send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return &identity, nil
+}
+
+// claimContains returns true if the claim is the value or a list including the value
+func claimContains(claim interface{}, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, c := range claim {
+			if c == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getKey returns the signing key of the provider. The keys are fetched again when the key isn't
+// known, since providers rotate their keys, but at most once in oidcKeysRefetchInterval.
+func (p *oidcProvider) getKey(kid string) (interface{}, error) {
+	configuration, err := p.getConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeysRefetchInterval {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(configuration.JWKSURI, &keySet); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]interface{}{}
+	p.keysFetched = time.Now()
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.KeyID] = key
+		}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+// publicKey returns the key as *rsa.PublicKey or *ecdsa.PublicKey
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+}
+
+func (p *oidcProvider) getJSON(address string, v interface{}) error {
+	response, err := p.client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded %d", address, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
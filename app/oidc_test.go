@@ -0,0 +1,281 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockProvider is an OpenID Connect identity provider, which issues codes for the identity set by the test
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]mockCode
+	// keyFetches is the number of times the keys have been fetched
+	keyFetches int
+}
+
+type mockCode struct {
+	Nonce         string
+	Challenge     string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: map[string]mockCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, oidcConfiguration{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.keyFetches++
+		respondWithJSON(w, http.StatusOK, map[string][]jsonWebKey{"keys": {{
+			KeyType: "RSA",
+			KeyID:   "mock",
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		code, ok := p.codes[r.FormValue("code")]
+		delete(p.codes, r.FormValue("code"))
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != code.Challenge {
+			respondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            p.server.URL,
+			"aud":            "client",
+			"sub":            code.Subject,
+			"email":          code.Email,
+			"email_verified": code.EmailVerified,
+			"nonce":          code.Nonce,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		})
+		token.Header["kid"] = "mock"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			respondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+// login starts a login with the mock provider, logs in at the provider as the identity and returns the
+// response of the callback
+func (p *mockProvider) login(t *testing.T, cookie *http.Cookie, link bool, identity mockCode) *httptest.ResponseRecorder {
+	address := "/api/users/oidc/mock/login"
+	if link {
+		address += "?link=true"
+	}
+	req, _ := http.NewRequest("GET", address, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusFound, response.Code)
+	authorization, err := url.Parse(response.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authorization.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("Expected PKCE with S256. Got '%s'", query.Get("code_challenge_method"))
+	}
+
+	stateCookie := getCookie(response, oidcStateCookie)
+	if stateCookie == nil {
+		t.Fatal("Expected a state cookie")
+	}
+
+	identity.Nonce = query.Get("nonce")
+	identity.Challenge = query.Get("code_challenge")
+	code, _ := newRandomToken()
+	p.codes[code] = identity
+
+	req, _ = http.NewRequest("GET", "/api/users/oidc/mock/callback?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), nil)
+	req.AddCookie(stateCookie)
+	return executeRequest(req)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	testServer.OIDCProviders = map[string]*oidcProvider{
+		"mock": newOIDCProvider("mock", provider.server.URL, "client", "secret", "http://localhost/api/users/oidc/mock/callback"),
+	}
+	defer func() { testServer.OIDCProviders = map[string]*oidcProvider{} }()
+
+	req, _ := http.NewRequest("GET", "/api/users/oidc/unknown/login", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// The first login creates the user
+	response = provider.login(t, nil, false, mockCode{Subject: "subject1", Email: "new@localhost.com", EmailVerified: true})
+	checkResponseCode(t, http.StatusFound, response.Code)
+	if getCookie(response, "token") == nil {
+		t.Error("Expected a token cookie")
+	}
+	created := user{Username: "new@localhost.com"}
+	if err := created.getUserByUsername(testServer.DB); err != nil {
+		t.Fatalf("Expected the user to be created. Got '%s'", err)
+	}
+	if !created.Verified {
+		t.Error("Expected the address verified by the provider to be verified")
+	}
+
+	// The next login finds the user by the subject, even if the address has changed
+	response = provider.login(t, nil, false, mockCode{Subject: "subject1", Email: "changed@localhost.com", EmailVerified: true})
+	checkResponseCode(t, http.StatusFound, response.Code)
+	claims := &Claims{}
+	jwt.ParseWithClaims(getCookie(response, "token").Value, claims, tokenKeys.verificationKey)
+	if claims.UserID != created.UserID {
+		t.Errorf("Expected user '%s'. Got '%s'", created.UserID, claims.UserID)
+	}
+
+	// An existing account isn't linked unless the provider is trusted and the address is verified
+	response = provider.login(t, nil, false, mockCode{Subject: "subject2", Email: "user1@localhost.com", EmailVerified: true})
+	checkResponseCode(t, http.StatusConflict, response.Code)
+
+	testServer.OIDCProviders["mock"].Trusted = true
+	response = provider.login(t, nil, false, mockCode{Subject: "subject2", Email: "user1@localhost.com", EmailVerified: false})
+	checkResponseCode(t, http.StatusConflict, response.Code)
+
+	response = provider.login(t, nil, false, mockCode{Subject: "subject2", Email: "user1@localhost.com", EmailVerified: true})
+	checkResponseCode(t, http.StatusFound, response.Code)
+	linked := identity{Provider: "mock", Subject: "subject2"}
+	if err := linked.getIdentity(testServer.DB); err != nil || linked.UserID != userIDs[0] {
+		t.Errorf("Expected the identity to be linked to user '%s'. Got '%s'", userIDs[0], linked.UserID)
+	}
+
+	// A logged in user links an identity without logging in again
+	cookie := authenticate("user2@localhost.com", "password2")
+	response = provider.login(t, cookie, true, mockCode{Subject: "subject3", Email: "other@localhost.com"})
+	checkResponseCode(t, http.StatusFound, response.Code)
+	if getCookie(response, "token") != nil {
+		t.Error("Expected no token cookie when linking")
+	}
+
+	// An identity linked to another user can't be linked again
+	response = provider.login(t, cookie, true, mockCode{Subject: "subject1", Email: "new@localhost.com", EmailVerified: true})
+	checkResponseCode(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/users/me/identities", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var result identities
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Results != 1 || result.Identities[0].Subject != "subject3" {
+		t.Errorf("Expected the linked identity 'subject3'. Got '%v'", result.Identities)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me/identities/mock", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me/identities/mock", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	clearTables()
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	testServer.OIDCProviders = map[string]*oidcProvider{
+		"mock": newOIDCProvider("mock", provider.server.URL, "client", "secret", "http://localhost/api/users/oidc/mock/callback"),
+	}
+	defer func() { testServer.OIDCProviders = map[string]*oidcProvider{} }()
+
+	req, _ := http.NewRequest("GET", "/api/users/oidc/mock/callback?code=code&state=unknown", nil)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/users/oidc/mock/login", nil)
+	response = executeRequest(req)
+	authorization, _ := url.Parse(response.Header().Get("Location"))
+	state := authorization.Query().Get("state")
+	stateCookie := getCookie(response, oidcStateCookie)
+
+	// A callback in another browser than the one that started the login is rejected
+	provider.codes["code"] = mockCode{Nonce: authorization.Query().Get("nonce"), Challenge: authorization.Query().Get("code_challenge"), Subject: "subject1", Email: "new@localhost.com", EmailVerified: true}
+	req, _ = http.NewRequest("GET", "/api/users/oidc/mock/callback?code=code&state="+state, nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+	if getCookie(response, "token") != nil {
+		t.Error("Expected no token cookie without the state cookie")
+	}
+
+	// A code without the verifier of the state is rejected
+	req, _ = http.NewRequest("GET", "/api/users/oidc/mock/callback?code=unknown&state="+state, nil)
+	req.AddCookie(stateCookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// The state can be used only once
+	req, _ = http.NewRequest("GET", "/api/users/oidc/mock/callback?code=unknown&state="+state, nil)
+	req.AddCookie(stateCookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestOIDCKeyRefetch(t *testing.T) {
+	provider := newMockProvider(t)
+	defer provider.server.Close()
+	p := newOIDCProvider("mock", provider.server.URL, "client", "secret", "http://localhost/api/users/oidc/mock/callback")
+
+	if _, err := p.getKey("mock"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown keys don't make the keys fetched again right away
+	for i := 0; i < 3; i++ {
+		if _, err := p.getKey("unknown"); err == nil {
+			t.Error("Expected an error for an unknown key")
+		}
+	}
+	if provider.keyFetches != 1 {
+		t.Errorf("Expected the keys to be fetched once. Got '%d'", provider.keyFetches)
+	}
+
+	// After the interval the keys are fetched again, since the provider may have rotated them
+	p.keysFetched = time.Now().Add(-oidcKeysRefetchInterval)
+	p.getKey("unknown")
+	if provider.keyFetches != 2 {
+		t.Errorf("Expected the keys to be fetched twice. Got '%d'", provider.keyFetches)
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// pageCursor is a position in a listing ordered by (created, id). Cursors are handed to
+// clients as opaque strings, so the fields can change without breaking clients.
+type pageCursor struct {
+	Created time.Time `json:"c"`
+	ID      int       `json:"i"`
+	// Sort is the order of the listing the cursor was created for
+	Sort string `json:"s"`
+	// Backward is true for cursors pointing to the previous page
+	Backward bool `json:"b,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encode returns the opaque string presentation of the cursor
+func (c *pageCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor from its opaque string presentation
+func decodeCursor(value string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID < 1 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHashCost is the cost of hashing passwords with bcrypt
+const passwordHashCost = 8
+
+type forgotPassword struct {
+	Username string `json:"username" validate:"required,email"`
+}
+
+type resetPassword struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+type changePassword struct {
+	OldPassword string `json:"oldPassword" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,min=8"`
+}
+
+// passwordResetToken is a single-use token emailed to a user who forgot their password.
+// Only the hash of the token is stored.
+type passwordResetToken struct {
+	Hash    string
+	UserID  string
+	Expires time.Time
+	Used    bool
+	Created time.Time
+}
+
+func (s *Server) handleForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request forgotPassword
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err := s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Requests are counted like failed logins, so that the emails can't be used for flooding a mailbox
+		if !s.limitEmailRequests(w, r, "reset", request.Username) {
+			return
+		}
+
+		// The response is the same whether the user exists or not, so that it doesn't reveal the users. The
+		// email is sent in the background, so that the time of the response doesn't reveal them either.
+		user := user{Username: request.Username}
+		if err := user.getUserByUsername(s.DB); err != nil && err != sql.ErrNoRows {
+			log.Println(err.Error())
+		} else if err == nil {
+			go func() {
+				if err := s.sendPasswordResetEmail(s.DB, &user); err != nil {
+					log.Println(err.Error())
+				}
+			}()
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request resetPassword
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err := s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		var token passwordResetToken
+		if err := token.getPasswordResetToken(tx, request.Token); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+		if token.Used || time.Now().After(token.Expires) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), passwordHashCost)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// The other reset tokens of the user become useless, and whoever knew the old password is logged out
+		current := time.Now()
+		if err := updatePassword(tx, token.UserID, string(hashedPassword)); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := usePasswordResetTokens(tx, token.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := revokeUserTokens(tx, token.UserID, current); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request changePassword
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// The old password is required, so that a stolen token isn't enough for taking over the account
+		user := user{UserID: claims.UserID}
+		if err := user.getUserByID(s.DB); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.OldPassword)); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), passwordHashCost)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Every session is logged out, including those of whoever stole a token, and the user logs in
+		// again with the new password
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		if err := updatePassword(tx, claims.UserID, string(hashedPassword)); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := revokeUserTokens(tx, claims.UserID, time.Now()); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		clearTokenCookies(w)
+		respondWithError(w, http.StatusUnauthorized, "Password changed, log in again")
+	}
+}
+
+// sendPasswordResetEmail creates a new reset token for the user and emails the link for resetting the password
+func (s *Server) sendPasswordResetEmail(db queryer, user *user) error {
+	tokenValue, err := newRandomToken()
+	if err != nil {
+		return err
+	}
+
+	current := time.Now()
+	token := passwordResetToken{Hash: hashToken(tokenValue), UserID: user.UserID, Expires: current.Add(s.PasswordResetTTL), Created: current}
+	if err := token.createPasswordResetToken(db); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Reset your password at %s/reset-password?token=%s\n\nThe link expires in %s. If you didn't ask for resetting your password, you can ignore this email.",
+		s.AppURL, tokenValue, s.PasswordResetTTL)
+	return s.Mailer.Send(user.Username, "Reset your password", body)
+}
+
+func (t *passwordResetToken) createPasswordResetToken(db queryer) error {
+	_, err := db.Exec(
+		"INSERT INTO password_reset_tokens(token_hash, user_id, expires, used, created) VALUES($1, $2, $3, false, $4)",
+		t.Hash, t.UserID, t.Expires, t.Created)
+
+	return err
+}
+
+// getPasswordResetToken finds a reset token by its value and locks it until the end of the transaction
+func (t *passwordResetToken) getPasswordResetToken(db queryer, token string) error {
+	return db.QueryRow("SELECT token_hash, user_id, expires, used, created FROM password_reset_tokens WHERE token_hash=$1 FOR UPDATE",
+		hashToken(token)).Scan(&t.Hash, &t.UserID, &t.Expires, &t.Used, &t.Created)
+}
+
+// usePasswordResetTokens marks all the reset tokens of the user used
+func usePasswordResetTokens(db queryer, userID string) error {
+	_, err := db.Exec("UPDATE password_reset_tokens SET used=true WHERE user_id=$1", userID)
+	return err
+}
+
+func updatePassword(db queryer, userID, hashedPassword string) error {
+	_, err := db.Exec("UPDATE users SET password=$2, modified=$3 WHERE user_id=$1", userID, hashedPassword, time.Now())
+	return err
+}
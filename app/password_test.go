@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResetPassword(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	// The response doesn't reveal if the user exists
+	for _, username := range []string{"user1@localhost.com", "usernotfound@localhost.com"} {
+		var jsonStr = []byte(fmt.Sprintf(`{"username":"%s"}`, username))
+		req, _ := http.NewRequest("POST", "/api/users/password/forgot", bytes.NewBuffer(jsonStr))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+	}
+	if testMailer.lastEmail("usernotfound@localhost.com") != nil {
+		t.Error("Expected no email to an unknown user")
+	}
+	token := emailToken(t, "user1@localhost.com")
+
+	// Too short password
+	var jsonStr = []byte(fmt.Sprintf(`{"token":"%s", "password":"short"}`, token))
+	req, _ := http.NewRequest("POST", "/api/users/password/reset", bytes.NewBuffer(jsonStr))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	jsonStr = []byte(fmt.Sprintf(`{"token":"%s", "password":"newpassword1"}`, token))
+	req, _ = http.NewRequest("POST", "/api/users/password/reset", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// The token can be used only once
+	req, _ = http.NewRequest("POST", "/api/users/password/reset", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// The old password doesn't work anymore and the old tokens are revoked
+	jsonStr = []byte(`{"username":"user1@localhost.com", "password": "password1"}`)
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	jsonStr = []byte(`{"username":"user1@localhost.com", "password": "newpassword1"}`)
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestForgotPasswordLimit(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Requests are limited per username whether the user exists or not
+	for _, username := range []string{"user1@localhost.com", "usernotfound@localhost.com"} {
+		var jsonStr = []byte(fmt.Sprintf(`{"username":"%s"}`, username))
+		for i := 0; i < emailRequestMaxRequests; i++ {
+			req, _ := http.NewRequest("POST", "/api/users/password/forgot", bytes.NewBuffer(jsonStr))
+			response := executeRequest(req)
+			checkResponseCode(t, http.StatusOK, response.Code)
+		}
+
+		req, _ := http.NewRequest("POST", "/api/users/password/forgot", bytes.NewBuffer(jsonStr))
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusTooManyRequests, response.Code)
+		if response.Header().Get("Retry-After") == "" {
+			t.Error("Expected the header Retry-After")
+		}
+	}
+
+	// Logging in isn't locked out by the requests
+	req, _ := http.NewRequest("POST", "/api/users/login", bytes.NewBuffer([]byte(`{"username":"user1@localhost.com", "password": "password1"}`)))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	// Wrong old password
+	var jsonStr = []byte(`{"oldPassword":"wrongpassword", "newPassword":"newpassword1"}`)
+	req, _ := http.NewRequest("PUT", "/api/users/password", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// Changing the password logs out every session, including the current one
+	jsonStr = []byte(`{"oldPassword":"password1", "newPassword":"newpassword1"}`)
+	req, _ = http.NewRequest("PUT", "/api/users/password", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	jsonStr = []byte(`{"username":"user1@localhost.com", "password": "newpassword1"}`)
+	req, _ = http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// record is a personal record of a user for an exercise. Records are computed from the
+// sets of the user, so updating or deleting a set is reflected in the records immediately.
+// When several sets share the best value, the earliest one holds the record.
+type record struct {
+	Type        string    `json:"type"`
+	ExerciseID  int       `json:"exerciseId"`
+	Exercise    string    `json:"exercise"`
+	Value       float64   `json:"value"`
+	SetID       int       `json:"setId"`
+	Weight      float64   `json:"weight"`
+	Repetitions int       `json:"repetitions"`
+	Created     time.Time `json:"created"`
+	// contenders is the number of sets that competed for the record
+	contenders int
+}
+
+type records struct {
+	Unit    string   `json:"unit"`
+	Results int      `json:"results"`
+	Records []record `json:"records"`
+}
+
+// setWithRecords is a set including the personal records it set
+type setWithRecords struct {
+	set
+	NewRecords []record `json:"newRecords"`
+}
+
+// recordTypes lists the types of personal records with the SQL expression of the value
+// and the columns that define which sets compete for the same record
+var recordTypes = []struct {
+	name      string
+	value     string
+	partition string
+}{
+	{"heaviestWeight", "s.weight", "s.exercise_id"},
+	{"mostRepetitions", "s.repetitions", "s.exercise_id, s.weight"},
+	{"estimated1RM", oneRepMaxFormulas["epley"], "s.exercise_id"},
+	{"bestVolume", "s.weight * s.repetitions", "s.exercise_id"},
+}
+
+func (s *Server) handleGetRecords() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		exerciseID := 0
+		if r.FormValue("exerciseId") != "" {
+			exerciseID, err = strconv.Atoi(r.FormValue("exerciseId"))
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusBadRequest, "Invalid exercise ID")
+				return
+			}
+		}
+
+		result, err := getRecords(s.DB, claims.UserID, exerciseID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		for i := range result {
+			result[i].fromKilograms(unit)
+		}
+		respondWithJSON(w, http.StatusOK, records{Unit: unit, Results: len(result), Records: result})
+	}
+}
+
+// getRecords returns the personal records of the user, either for all exercises (exerciseID 0) or for one exercise
+func getRecords(db *sql.DB, userID string, exerciseID int) ([]record, error) {
+	var queries []string
+	for _, t := range recordTypes {
+		queries = append(queries, fmt.Sprintf(
+			`(SELECT DISTINCT ON (%[2]s) '%[1]s', s.exercise_id, e.name, ROUND(%[3]s, 2), s.id, s.weight, s.repetitions, s.created, COUNT(*) OVER (PARTITION BY %[2]s)
+			FROM sets s JOIN exercises e ON e.id=s.exercise_id
+			WHERE s.user_id=$1 AND s.repetitions > 0 AND ($2=0 OR s.exercise_id=$2)
+			ORDER BY %[2]s, %[3]s DESC, s.created ASC, s.id ASC)`,
+			t.name, t.partition, t.value))
+	}
+
+	rows, err := db.Query(strings.Join(queries, " UNION ALL ")+" ORDER BY 3, 1, 6", userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []record{}
+	for rows.Next() {
+		var r record
+		if err := rows.Scan(&r.Type, &r.ExerciseID, &r.Exercise, &r.Value, &r.SetID, &r.Weight, &r.Repetitions, &r.Created, &r.contenders); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// getNewRecords returns the personal records the set beat. The first set of an exercise
+// or of a weight has nothing to beat, so it doesn't count as a new record. When the set is
+// updated, the records before the update are given, and the records the set already held
+// with the same value aren't new.
+func (s *set) getNewRecords(db *sql.DB, userID string, previous []record) ([]record, error) {
+	records, err := getRecords(db, userID, s.ExerciseID)
+	if err != nil {
+		return nil, err
+	}
+
+	newRecords := []record{}
+	for _, r := range records {
+		if r.SetID == s.ID && r.contenders > 1 && !r.heldIn(previous) {
+			newRecords = append(newRecords, r)
+		}
+	}
+
+	return newRecords, nil
+}
+
+// heldIn checks if the same set held the record with the same value in the records
+func (r *record) heldIn(records []record) bool {
+	for _, p := range records {
+		if p.Type == r.Type && p.ExerciseID == r.ExerciseID && p.Weight == r.Weight && p.SetID == r.SetID && p.Value == r.Value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestNewRecords(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	// The first set has nothing to beat
+	m := createSet(t, cookie, fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	if len(m.NewRecords) != 0 {
+		t.Errorf("Expected no new records for the first set. Got '%v'", m.NewRecords)
+	}
+
+	// Heavier set with less volume
+	m = createSet(t, cookie, fmt.Sprintf(`{"weight": 110, "exerciseId":%d, "repetitions":3}`, getExerciseID("Squat")))
+	heaviest := m.ID
+	types := recordTypeNames(m.NewRecords)
+	if types != "heaviestWeight,estimated1RM," {
+		t.Errorf("Expected new records 'heaviestWeight,estimated1RM,'. Got '%v'", types)
+	}
+
+	// More repetitions at the same weight
+	m = createSet(t, cookie, fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":6}`, getExerciseID("Squat")))
+	types = recordTypeNames(m.NewRecords)
+	if types != "mostRepetitions,bestVolume," {
+		t.Errorf("Expected new records 'mostRepetitions,bestVolume,'. Got '%v'", types)
+	}
+
+	// Updating the notes of a set holding records doesn't report the records again
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 110, "exerciseId":%d, "repetitions":3, "notes":"Felt heavy"}`, getExerciseID("Squat")))
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/sets/%d", heaviest), bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if len(m.NewRecords) != 0 {
+		t.Errorf("Expected no new records. Got '%v'", m.NewRecords)
+	}
+}
+
+func TestRecordsAfterDeleteAndUpdate(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	createSet(t, cookie, fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	heaviest := createSet(t, cookie, fmt.Sprintf(`{"weight": 110, "exerciseId":%d, "repetitions":3}`, getExerciseID("Squat")))
+
+	if record := getRecord(t, cookie, "heaviestWeight"); record.Value != 110 {
+		t.Errorf("Expected heaviest weight to be '110'. Got '%v'", record.Value)
+	}
+
+	// Deleting the set holding the record gives the record back to the previous set
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/sets/%d", heaviest.ID), nil)
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	if record := getRecord(t, cookie, "heaviestWeight"); record.Value != 100 {
+		t.Errorf("Expected heaviest weight to be '100'. Got '%v'", record.Value)
+	}
+
+	// Updating the set holding the record to a lighter weight
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 90, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	req, _ = http.NewRequest("PUT", "/api/v1/sets/1", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	if record := getRecord(t, cookie, "heaviestWeight"); record.Value != 90 {
+		t.Errorf("Expected heaviest weight to be '90'. Got '%v'", record.Value)
+	}
+}
+
+func createSet(t *testing.T, cookie *http.Cookie, body string) setWithRecords {
+	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer([]byte(body)))
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var m setWithRecords
+	json.Unmarshal(response.Body.Bytes(), &m)
+	return m
+}
+
+func getRecord(t *testing.T, cookie *http.Cookie, recordType string) record {
+	req, _ := http.NewRequest("GET", "/api/v1/records", nil)
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m records
+	json.Unmarshal(response.Body.Bytes(), &m)
+	for _, r := range m.Records {
+		if r.Type == recordType {
+			return r
+		}
+	}
+
+	t.Errorf("Expected to find a record of type '%s'", recordType)
+	return record{}
+}
+
+func recordTypeNames(records []record) string {
+	names := ""
+	for _, t := range recordTypes {
+		for _, r := range records {
+			if r.Type == t.name {
+				names += r.Type + ","
+			}
+		}
+	}
+	return names
+}
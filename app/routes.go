@@ -11,19 +11,92 @@ import (
 
 func (s *Server) routes() {
 	// Authentication
+	s.Router.HandleFunc("/.well-known/jwks.json", s.logHTTP(s.handleJWKS())).Methods(http.MethodGet)
 	s.Router.HandleFunc("/api/users/login", s.logHTTP(s.handleLogin())).Methods(http.MethodPost)
-	s.Router.HandleFunc("/api/users/refresh", s.authenticate(s.logHTTP(s.handleRefresh()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/login/totp", s.logHTTP(s.handleLoginTOTP())).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/refresh", s.logHTTP(s.handleRefresh())).Methods(http.MethodPost)
 	s.Router.HandleFunc("/api/users/register", s.logHTTP(s.handleRegister())).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/verify", s.logHTTP(s.handleVerifyEmail())).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/verify/resend", s.logHTTP(s.handleResendVerification())).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/logout", s.authenticate(s.logHTTP(s.handleLogout()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/logout/everywhere", s.authenticate(s.logHTTP(s.handleLogoutEverywhere()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/password", s.authenticate(s.logHTTP(s.handleChangePassword()))).Methods(http.MethodPut)
+	s.Router.HandleFunc("/api/users/password/forgot", s.logHTTP(s.handleForgotPassword())).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/password/reset", s.logHTTP(s.handleResetPassword())).Methods(http.MethodPost)
+
+	// Sessions
+	s.Router.HandleFunc("/api/users/me/sessions", s.authenticate(s.logHTTP(s.handleGetSessions()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/me/sessions/{id}", s.authenticate(s.logHTTP(s.handleRevokeSession()))).Methods(http.MethodDelete)
+
+	// Two-factor authentication
+	s.Router.HandleFunc("/api/users/me/totp", s.authenticate(s.logHTTP(s.handleEnrollTOTP()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/me/totp/confirm", s.authenticate(s.logHTTP(s.handleConfirmTOTP()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/me/totp", s.authenticate(s.logHTTP(s.handleDisableTOTP()))).Methods(http.MethodDelete)
+
+	// External identity providers
+	s.Router.HandleFunc("/api/users/oidc/{provider}/login", s.logHTTP(s.handleOIDCLogin())).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/oidc/{provider}/callback", s.logHTTP(s.handleOIDCCallback())).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/me/identities", s.authenticate(s.logHTTP(s.handleGetIdentities()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/me/identities/{provider}", s.authenticate(s.logHTTP(s.handleDeleteIdentity()))).Methods(http.MethodDelete)
+
+	// Administration
+	s.Router.HandleFunc("/api/admin/users", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminGetUsers())))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/admin/users/{id}", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminGetUser())))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/admin/users/{id}/enable", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminSetEnabled(true))))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/admin/users/{id}/disable", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminSetEnabled(false))))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/admin/users/{id}/password/reset", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminResetPassword())))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/admin/users/{id}/authorities", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminGrantAuthority())))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/admin/users/{id}/authorities/{authority}", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminRevokeAuthority())))).Methods(http.MethodDelete)
+	s.Router.HandleFunc("/api/admin/audit", s.authenticate(s.requireRole(roleAdmin, s.logHTTP(s.handleAdminGetAuditLog())))).Methods(http.MethodGet)
+
+	// API keys
+	s.Router.HandleFunc("/api/users/me/keys", s.authenticate(s.logHTTP(s.handleGetAPIKeys()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/me/keys", s.authenticate(s.logHTTP(s.handleCreateAPIKey()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/me/keys/{id}", s.authenticate(s.logHTTP(s.handleRevokeAPIKey()))).Methods(http.MethodDelete)
+
+	// Account
+	s.Router.HandleFunc("/api/users/me", s.authenticate(s.logHTTP(s.handleDeleteAccount()))).Methods(http.MethodDelete)
+	s.Router.HandleFunc("/api/users/me/restore", s.authenticate(s.logHTTP(s.handleRestoreAccount()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/users/me/export", s.authenticate(s.logHTTP(s.handleExportAccount()))).Methods(http.MethodGet)
+
+	// Preferences
+	s.Router.HandleFunc("/api/users/me/preferences", s.authenticate(s.logHTTP(s.handleGetPreferences()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/users/me/preferences", s.authenticate(s.logHTTP(s.handleUpdatePreferences()))).Methods(http.MethodPut)
 
 	// Heartbeat
 	s.Router.HandleFunc("/api/heartbeat", s.authenticate(s.logHTTP(s.handleHeartbeat()))).Methods(http.MethodGet)
 
 	// Manage sets
-	s.Router.HandleFunc("/api/v1/sets", s.authenticate(s.logHTTP(s.handleGetSets()))).Methods(http.MethodGet)
-	s.Router.HandleFunc("/api/v1/sets", s.authenticate(s.logHTTP(s.handleCreateSet()))).Methods(http.MethodPost)
-	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticate(s.logHTTP(s.handleGetSet()))).Methods(http.MethodGet)
-	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticate(s.logHTTP(s.handleUpdateSet()))).Methods(http.MethodPut)
-	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticate(s.logHTTP(s.handleDeleteSet()))).Methods(http.MethodDelete)
+	s.Router.HandleFunc("/api/v1/sets", s.authenticateScope(scopeSetsRead, s.logHTTP(s.handleGetSets()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/sets", s.authenticateScope(scopeSetsWrite, s.logHTTP(s.handleCreateSet()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticateScope(scopeSetsRead, s.logHTTP(s.handleGetSet()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticateScope(scopeSetsWrite, s.logHTTP(s.handleUpdateSet()))).Methods(http.MethodPut)
+	s.Router.HandleFunc("/api/v1/sets/{id:[0-9]+}", s.authenticateScope(scopeSetsWrite, s.logHTTP(s.handleDeleteSet()))).Methods(http.MethodDelete)
+	s.Router.HandleFunc("/api/v1/sets/batch", s.authenticateScope(scopeSetsWrite, s.logHTTP(s.handleSetBatch()))).Methods(http.MethodPost)
+
+	// Export and import
+	s.Router.HandleFunc("/api/v1/export.csv", s.authenticateScope(scopeSetsRead, s.logHTTP(s.handleExportCSV()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/import", s.authenticateScope(scopeSetsWrite, s.logHTTP(s.handleImport()))).Methods(http.MethodPost)
+
+	// Personal records
+	s.Router.HandleFunc("/api/v1/records", s.authenticateScope(scopeSetsRead, s.logHTTP(s.handleGetRecords()))).Methods(http.MethodGet)
+
+	// Statistics
+	s.Router.HandleFunc("/api/v1/stats", s.authenticateScope(scopeSetsRead, s.logHTTP(s.handleGetStats()))).Methods(http.MethodGet)
+
+	// Manage exercises
+	s.Router.HandleFunc("/api/v1/exercises", s.authenticateScope(scopeExercisesRead, s.logHTTP(s.handleGetExercises()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/exercises", s.authenticateScope(scopeExercisesWrite, s.logHTTP(s.handleCreateExercise()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/v1/exercises/{id:[0-9]+}", s.authenticateScope(scopeExercisesRead, s.logHTTP(s.handleGetExercise()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/exercises/{id:[0-9]+}", s.authenticateScope(scopeExercisesWrite, s.logHTTP(s.handleUpdateExercise()))).Methods(http.MethodPut)
+	s.Router.HandleFunc("/api/v1/exercises/{id:[0-9]+}", s.authenticateScope(scopeExercisesWrite, s.logHTTP(s.handleDeleteExercise()))).Methods(http.MethodDelete)
+
+	// Manage workouts
+	s.Router.HandleFunc("/api/v1/workouts", s.authenticateScope(scopeWorkoutsRead, s.logHTTP(s.handleGetWorkouts()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/workouts", s.authenticateScope(scopeWorkoutsWrite, s.logHTTP(s.handleCreateWorkout()))).Methods(http.MethodPost)
+	s.Router.HandleFunc("/api/v1/workouts/{id:[0-9]+}", s.authenticateScope(scopeWorkoutsRead, s.logHTTP(s.handleGetWorkout()))).Methods(http.MethodGet)
+	s.Router.HandleFunc("/api/v1/workouts/{id:[0-9]+}", s.authenticateScope(scopeWorkoutsWrite, s.logHTTP(s.handleUpdateWorkout()))).Methods(http.MethodPut)
+	s.Router.HandleFunc("/api/v1/workouts/{id:[0-9]+}", s.authenticateScope(scopeWorkoutsWrite, s.logHTTP(s.handleDeleteWorkout()))).Methods(http.MethodDelete)
 }
 
 // func (s *Server) cors(h http.HandlerFunc) http.HandlerFunc {
@@ -40,35 +113,23 @@ func (s *Server) routes() {
 
 func (s *Server) authenticate(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		claims := &Claims{}
-
-		// Check that cookie is present
-		c, err := r.Cookie("token")
+		// Check that a valid token is present
+		claims, err := readClaims(r)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				log.Println("No token cookie present")
-				respondWithError(w, http.StatusUnauthorized, "No token cookie present")
+			log.Println(err.Error())
+			// Tokens signed with a key that has been removed are unverifiable, and the client should log in again
+			if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorExpired|jwt.ValidationErrorUnverifiable) != 0 {
+				respondWithError(w, http.StatusUnauthorized, "Invalid token")
 				return
 			}
-			respondWithError(w, http.StatusBadRequest, "Invalid cookie")
-			return
-		}
-
-		// Validate token
-		tkn, err := jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				log.Println("Err sign invalid")
-				respondWithError(w, http.StatusUnauthorized, "Err sign invalid")
+			if err == errNoToken {
+				respondWithError(w, http.StatusUnauthorized, "No token present")
+				return
+			}
+			if err == errAPIKeyNotAllowed {
+				respondWithError(w, http.StatusForbidden, "API keys can't be used for this request")
 				return
 			}
-			respondWithError(w, http.StatusBadRequest, "Invalid cookie")
-			return
-		}
-		if !tkn.Valid {
-			log.Println("Invalid token")
 			respondWithError(w, http.StatusBadRequest, "Invalid token")
 			return
 		}
@@ -80,6 +141,19 @@ func (s *Server) authenticate(h http.HandlerFunc) http.HandlerFunc {
 			respondWithError(w, http.StatusBadRequest, "Invalid token")
 			return
 		}
+
+		// Check that the token hasn't been revoked by logging out
+		revoked, err := checkIfTokenRevoked(s.DB, claims)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if revoked {
+			log.Println("Token revoked")
+			respondWithError(w, http.StatusUnauthorized, "Token revoked")
+			return
+		}
 		h.ServeHTTP(w, r)
 	}
 }
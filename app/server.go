@@ -6,6 +6,7 @@ import (
 	"log"
 	"reflect"
 	"strings"
+	"time"
 
 	"net/http"
 
@@ -21,6 +22,30 @@ type Server struct {
 	Router    *mux.Router
 	DB        *sql.DB
 	Validator *validator.Validate
+	// MaxPageSize is the maximum number of items returned in one page of a listing
+	MaxPageSize int
+	// AccessTokenTTL is the lifetime of access tokens and RefreshTokenTTL the lifetime of refresh tokens
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// PasswordResetTTL is the lifetime of the tokens emailed for resetting passwords
+	PasswordResetTTL time.Duration
+	// EmailVerificationTTL is the lifetime of the tokens emailed for verifying email addresses
+	EmailVerificationTTL time.Duration
+	// AccountDeletionGrace is the time after which an account is deleted when the user asks for deleting it
+	AccountDeletionGrace time.Duration
+	// LoginMaxFailures is the number of failed logins per username and LoginMaxIPFailures per IP address after
+	// which logging in is locked out for LoginLockout, which doubles with each further failure
+	LoginMaxFailures   int
+	LoginMaxIPFailures int
+	LoginLockout       time.Duration
+	// TrustForwardedFor is true when the application is behind a proxy, which sets X-Forwarded-For
+	TrustForwardedFor bool
+	// OIDCProviders are the OpenID Connect identity providers users can log in with, by name
+	OIDCProviders map[string]*oidcProvider
+	// AppURL is the address of the web client used in the links of emails
+	AppURL string
+	// Mailer sends the emails of the application
+	Mailer Mailer
 }
 
 // Initialize initializes the app
@@ -44,6 +69,30 @@ func (s *Server) Initialize(user, password, dbname, dbhost string) {
 		}
 		return name
 	})
+	s.Validator.RegisterValidation("tempo", func(fl validator.FieldLevel) bool {
+		return tempoPattern.MatchString(fl.Field().String())
+	})
+
+	// Keys of the access tokens
+	tokenKeys, err = loadTokenKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configuration
+	s.MaxPageSize = getEnvInt("MAX_PAGE_SIZE", 100)
+	s.AccessTokenTTL = getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	s.RefreshTokenTTL = getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	s.PasswordResetTTL = getEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	s.EmailVerificationTTL = getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	s.AccountDeletionGrace = getEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+	s.LoginMaxFailures = getEnvInt("LOGIN_MAX_FAILURES", 5)
+	s.LoginMaxIPFailures = getEnvInt("LOGIN_MAX_IP_FAILURES", 50)
+	s.LoginLockout = getEnvDuration("LOGIN_LOCKOUT", time.Minute)
+	s.TrustForwardedFor = getEnv("TRUST_FORWARDED_FOR", "false") == "true"
+	s.OIDCProviders = newOIDCProviders()
+	s.AppURL = getEnv("APP_URL", "http://localhost:3000")
+	s.Mailer = newMailer()
 
 	// Router
 	s.Router = mux.NewRouter()
@@ -54,5 +103,26 @@ func (s *Server) Initialize(user, password, dbname, dbhost string) {
 // Run starts the HTTP-server
 func (s *Server) Run(addr string) {
 	log.Println("Starting an HTTP-server!")
+	go s.cleanUpPeriodically(time.Hour)
 	log.Fatal(http.ListenAndServe(":8010", s.Router))
 }
+
+// cleanUpPeriodically deletes the accounts whose grace period has ended, the expired login failures,
+// the expired logins at identity providers and the ended sessions, until the application stops
+func (s *Server) cleanUpPeriodically(interval time.Duration) {
+	for {
+		if err := deleteScheduledAccounts(s.DB); err != nil {
+			log.Println(err.Error())
+		}
+		if err := deleteExpiredLoginFailures(s.DB); err != nil {
+			log.Println(err.Error())
+		}
+		if err := deleteExpiredOIDCStates(s.DB); err != nil {
+			log.Println(err.Error())
+		}
+		if err := deleteExpiredSessions(s.DB); err != nil {
+			log.Println(err.Error())
+		}
+		time.Sleep(interval)
+	}
+}
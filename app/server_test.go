@@ -15,6 +15,7 @@ func TestMain(m *testing.M) {
 		os.Getenv("DB_PASSWORD"),
 		os.Getenv("DB_NAME"),
 		os.Getenv("DB_HOST"))
+	testServer.Mailer = &testMailer
 
 	ensureTablesExist()
 	code := m.Run()
@@ -24,8 +25,23 @@ func TestMain(m *testing.M) {
 
 func ensureTablesExist() {
 	var tables []string
-	tables = append(tables, setsTableCreationQuery)
 	tables = append(tables, usersTableCreationQuery)
+	tables = append(tables, workoutsTableCreationQuery)
+	tables = append(tables, exercisesTableCreationQuery)
+	tables = append(tables, setsTableCreationQuery)
+	tables = append(tables, authoritiesTableCreationQuery)
+	tables = append(tables, refreshTokensTableCreationQuery)
+	tables = append(tables, revokedTokensTableCreationQuery)
+	tables = append(tables, passwordResetTokensTableCreationQuery)
+	tables = append(tables, emailVerificationTokensTableCreationQuery)
+	tables = append(tables, loginChallengesTableCreationQuery)
+	tables = append(tables, totpRecoveryCodesTableCreationQuery)
+	tables = append(tables, adminAuditLogTableCreationQuery)
+	tables = append(tables, loginFailuresTableCreationQuery)
+	tables = append(tables, oidcStatesTableCreationQuery)
+	tables = append(tables, userIdentitiesTableCreationQuery)
+	tables = append(tables, apiKeysTableCreationQuery)
+	tables = append(tables, sessionsTableCreationQuery)
 
 	for _, table := range tables {
 		if _, err := testServer.DB.Exec(table); err != nil {
@@ -36,29 +52,246 @@ func ensureTablesExist() {
 
 func clearTables() {
 	testServer.DB.Exec("DELETE FROM sets")
+	testServer.DB.Exec("DELETE FROM workouts")
+	testServer.DB.Exec("DELETE FROM exercises WHERE user_id IS NOT NULL")
+	testServer.DB.Exec("DELETE FROM authorities")
+	testServer.DB.Exec("DELETE FROM refresh_tokens")
+	testServer.DB.Exec("DELETE FROM revoked_tokens")
+	testServer.DB.Exec("DELETE FROM password_reset_tokens")
+	testServer.DB.Exec("DELETE FROM email_verification_tokens")
+	testServer.DB.Exec("DELETE FROM login_challenges")
+	testServer.DB.Exec("DELETE FROM totp_recovery_codes")
+	testServer.DB.Exec("DELETE FROM admin_audit_log")
+	testServer.DB.Exec("DELETE FROM login_failures")
+	testServer.DB.Exec("DELETE FROM oidc_states")
+	testServer.DB.Exec("DELETE FROM user_identities")
+	testServer.DB.Exec("DELETE FROM api_keys")
+	testServer.DB.Exec("DELETE FROM sessions")
 	testServer.DB.Exec("DELETE FROM users")
+	testMailer.clear()
 	testServer.DB.Exec("ALTER SEQUENCE sets_id_seq RESTART WITH 1")
+	testServer.DB.Exec("ALTER SEQUENCE workouts_id_seq RESTART WITH 1")
 }
 
 const setsTableCreationQuery = `CREATE TABLE IF NOT EXISTS sets
 (
     id SERIAL,
-    user_id TEXT NOT NULL,
-	weight NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-	exercise TEXT NOT NULL,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	workout_id INTEGER REFERENCES workouts(id) ON DELETE SET NULL,
+	weight NUMERIC(10,4) NOT NULL DEFAULT 0.00,
+	exercise_id INTEGER NOT NULL REFERENCES exercises(id),
 	repetitions INTEGER,
+	rpe NUMERIC(3,1),
+	rir INTEGER,
+	tempo TEXT NOT NULL DEFAULT '',
+	rest_seconds INTEGER,
+	duration_seconds INTEGER,
+	distance NUMERIC(10,2),
+	set_type TEXT NOT NULL DEFAULT 'working',
+	notes TEXT NOT NULL DEFAULT '',
+	import_key TEXT,
 	created TIMESTAMP WITH TIME ZONE NOT NULL,
     modified TIMESTAMP WITH TIME ZONE NOT NULL,
 	CONSTRAINT sets_pkey PRIMARY KEY (id)
 )`
 
+const workoutsTableCreationQuery = `CREATE TABLE IF NOT EXISTS workouts
+(
+    id SERIAL,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	started TIMESTAMP WITH TIME ZONE NOT NULL,
+	ended TIMESTAMP WITH TIME ZONE,
+	notes TEXT NOT NULL DEFAULT '',
+	location TEXT NOT NULL DEFAULT '',
+	import_key TEXT,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    modified TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT workouts_pkey PRIMARY KEY (id)
+)`
+
+const exercisesTableCreationQuery = `CREATE TABLE IF NOT EXISTS exercises
+(
+    id SERIAL,
+    user_id TEXT,
+	name TEXT NOT NULL,
+	muscle_group TEXT NOT NULL DEFAULT '',
+	equipment TEXT NOT NULL DEFAULT '',
+	category TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    modified TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT exercises_pkey PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_exercises_user_id_name
+    on exercises (COALESCE(user_id, ''), LOWER(name));
+INSERT INTO exercises(name, muscle_group, equipment, category, created, modified) VALUES
+    ('Squat', 'quadriceps', 'barbell', 'strength', NOW(), NOW()),
+    ('Bench press', 'chest', 'barbell', 'strength', NOW(), NOW()),
+    ('Deadlift', 'back', 'barbell', 'strength', NOW(), NOW())
+ON CONFLICT DO NOTHING;
+CREATE TABLE IF NOT EXISTS exercise_aliases
+(
+    alias TEXT NOT NULL,
+	exercise_id INTEGER NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+	CONSTRAINT exercise_aliases_pkey PRIMARY KEY (alias)
+);
+INSERT INTO exercise_aliases(alias, exercise_id)
+    SELECT a.alias, e.id FROM (VALUES ('bench', 'Bench press'), ('bp', 'Bench press')) AS a(alias, name)
+    JOIN exercises e ON e.user_id IS NULL AND e.name = a.name
+ON CONFLICT DO NOTHING`
+
 const usersTableCreationQuery = `CREATE TABLE IF NOT EXISTS users
 (
     user_id TEXT NOT NULL,
     username TEXT NOT NULL,
     password TEXT NOT NULL,
 	enabled INTEGER NOT NULL DEFAULT 1,
+	weight_unit TEXT NOT NULL DEFAULT 'kg',
+	tokens_valid_after TIMESTAMP WITH TIME ZONE,
+	verified BOOLEAN NOT NULL DEFAULT false,
+	totp_secret TEXT,
+	totp_enabled BOOLEAN NOT NULL DEFAULT false,
+	totp_last_step BIGINT NOT NULL DEFAULT 0,
+	deletion_scheduled TIMESTAMP WITH TIME ZONE,
 	created TIMESTAMP WITH TIME ZONE NOT NULL,
     modified TIMESTAMP WITH TIME ZONE NOT NULL,
     CONSTRAINT users_pkey PRIMARY KEY (user_id)
 )`
+
+const refreshTokensTableCreationQuery = `CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    token_hash TEXT NOT NULL,
+    family_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false,
+	revoked BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (token_hash)
+)`
+
+const revokedTokensTableCreationQuery = `CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+)`
+
+const passwordResetTokensTableCreationQuery = `CREATE TABLE IF NOT EXISTS password_reset_tokens
+(
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT password_reset_tokens_pkey PRIMARY KEY (token_hash)
+)`
+
+const emailVerificationTokensTableCreationQuery = `CREATE TABLE IF NOT EXISTS email_verification_tokens
+(
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT email_verification_tokens_pkey PRIMARY KEY (token_hash)
+)`
+
+const loginChallengesTableCreationQuery = `CREATE TABLE IF NOT EXISTS login_challenges
+(
+    challenge_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	used BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT login_challenges_pkey PRIMARY KEY (challenge_hash)
+)`
+
+const totpRecoveryCodesTableCreationQuery = `CREATE TABLE IF NOT EXISTS totp_recovery_codes
+(
+    code_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT totp_recovery_codes_pkey PRIMARY KEY (code_hash)
+)`
+
+const authoritiesTableCreationQuery = `CREATE TABLE IF NOT EXISTS authorities
+(
+    user_id TEXT NOT NULL,
+    authority TEXT NOT NULL,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+	modified TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT authorities_pkey PRIMARY KEY (user_id, authority)
+)`
+
+const adminAuditLogTableCreationQuery = `CREATE TABLE IF NOT EXISTS admin_audit_log
+(
+    id SERIAL,
+    admin_user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+	target_user_id TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT admin_audit_log_pkey PRIMARY KEY (id)
+)`
+
+const loginFailuresTableCreationQuery = `CREATE TABLE IF NOT EXISTS login_failures
+(
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP WITH TIME ZONE,
+	last_failure TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT login_failures_pkey PRIMARY KEY (key)
+)`
+
+const oidcStatesTableCreationQuery = `CREATE TABLE IF NOT EXISTS oidc_states
+(
+    state_hash TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	user_id TEXT,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	used BOOLEAN NOT NULL DEFAULT false,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT oidc_states_pkey PRIMARY KEY (state_hash)
+)`
+
+const userIdentitiesTableCreationQuery = `CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT user_identities_pkey PRIMARY KEY (provider, subject)
+)`
+
+const apiKeysTableCreationQuery = `CREATE TABLE IF NOT EXISTS api_keys
+(
+    id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL,
+	last_used TIMESTAMP WITH TIME ZONE,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id)
+)`
+
+const sessionsTableCreationQuery = `CREATE TABLE IF NOT EXISTS sessions
+(
+    id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+	last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+	expires TIMESTAMP WITH TIME ZONE NOT NULL,
+	revoked BOOLEAN NOT NULL DEFAULT false,
+    CONSTRAINT sessions_pkey PRIMARY KEY (id)
+)`
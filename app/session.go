@@ -0,0 +1,186 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxUserAgentLength is the longest user agent stored for a session
+const maxUserAgentLength = 512
+
+// session is a login on a device. The ID of the session is the family of its refresh tokens, and the
+// access tokens carry it in the claim sid, so that revoking the session revokes its tokens immediately.
+type session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	UserAgent string    `json:"userAgent"`
+	IPAddress string    `json:"ipAddress"`
+	Current   bool      `json:"current"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Expires   time.Time `json:"expires"`
+}
+
+type sessions struct {
+	Results  int       `json:"results"`
+	Sessions []session `json:"sessions"`
+}
+
+func (s *Server) handleGetSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		result, err := getActiveSessions(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		for i := range result {
+			result[i].Current = result[i].ID == claims.SessionID
+		}
+
+		respondWithJSON(w, http.StatusOK, sessions{Results: len(result), Sessions: result})
+	}
+}
+
+// handleRevokeSession logs out the device of the session. Its refresh tokens stop working and
+// authenticate rejects its access tokens.
+func (s *Server) handleRevokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		sessionID := mux.Vars(r)["id"]
+		affectedRows, err := revokeSession(tx, claims.UserID, sessionID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		if err := revokeTokenFamily(tx, sessionID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Revoking the current session is logging out
+		if sessionID == claims.SessionID {
+			clearTokenCookies(w)
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// recordSession stores the session when the user logs in and updates it on every refresh. Sessions of the
+// refresh tokens issued before sessions were recorded are created on their first refresh.
+func (s *Server) recordSession(db queryer, r *http.Request, sessionID, userID string, expires time.Time) error {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	current := time.Now()
+	_, err := db.Exec(
+		`INSERT INTO sessions(id, user_id, user_agent, ip_address, created, last_seen, expires, revoked) VALUES($1, $2, $3, $4, $5, $5, $6, false)
+		ON CONFLICT (id) DO UPDATE SET user_agent=EXCLUDED.user_agent, ip_address=EXCLUDED.ip_address, last_seen=EXCLUDED.last_seen, expires=EXCLUDED.expires`,
+		sessionID, userID, userAgent, s.clientIP(r), current, expires)
+	return err
+}
+
+// getActiveSessions returns the sessions that can still be refreshed, the most recently seen first
+func getActiveSessions(db queryer, userID string) ([]session, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, user_agent, ip_address, created, last_seen, expires FROM sessions WHERE user_id=$1 AND NOT revoked AND expires > $2 ORDER BY last_seen DESC",
+		userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []session{}
+	for rows.Next() {
+		var se session
+		if err := rows.Scan(&se.ID, &se.UserID, &se.UserAgent, &se.IPAddress, &se.Created, &se.LastSeen, &se.Expires); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, se)
+	}
+
+	return sessions, rows.Err()
+}
+
+// exportSessions returns every stored session of the user, including the revoked ones. Sessions are deleted
+// when they expire.
+func exportSessions(db queryer, userID string) ([]session, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, user_agent, ip_address, revoked, created, last_seen, expires FROM sessions WHERE user_id=$1 ORDER BY created",
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []session{}
+	for rows.Next() {
+		var se session
+		if err := rows.Scan(&se.ID, &se.UserID, &se.UserAgent, &se.IPAddress, &se.Revoked, &se.Created, &se.LastSeen, &se.Expires); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, se)
+	}
+
+	return sessions, rows.Err()
+}
+
+// revokeSession revokes an active session of the user
+func revokeSession(db queryer, userID, sessionID string) (int64, error) {
+	result, err := db.Exec("UPDATE sessions SET revoked=true WHERE id=$2 AND user_id=$1 AND NOT revoked", userID, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// deleteExpiredSessions removes the sessions that have expired. Revoked sessions are kept until they expire, so
+// that the export of the account shows them. The access tokens of a deleted session are rejected like those of
+// a revoked session.
+func deleteExpiredSessions(db queryer) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE expires < $1", time.Now())
+	return err
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// loginFrom logs in with the user agent and returns the response of logging in
+func loginFrom(userAgent, username, password string) *http.Response {
+	req, _ := http.NewRequest("POST", "/api/users/login", bytes.NewBuffer([]byte(`{"username":"`+username+`", "password":"`+password+`"}`)))
+	req.Header.Set("User-Agent", userAgent)
+	return executeRequest(req).Result()
+}
+
+func TestSessions(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	phone := loginFrom("Phone", "user1@localhost.com", "password1")
+	laptop := loginFrom("Laptop", "user1@localhost.com", "password1")
+	var phoneToken, laptopToken *http.Cookie
+	for _, cookie := range phone.Cookies() {
+		if cookie.Name == "token" {
+			phoneToken = cookie
+		}
+	}
+	for _, cookie := range laptop.Cookies() {
+		if cookie.Name == "token" {
+			laptopToken = cookie
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/users/me/sessions", nil)
+	req.AddCookie(laptopToken)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var result sessions
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Results != 2 {
+		t.Fatalf("Expected 2 sessions. Got '%s'", response.Body.String())
+	}
+	var phoneSession session
+	for _, se := range result.Sessions {
+		if se.UserAgent == "Laptop" && !se.Current {
+			t.Error("Expected the session of the laptop to be current")
+		}
+		if se.UserAgent == "Phone" {
+			phoneSession = se
+		}
+	}
+	if phoneSession.ID == "" || phoneSession.Current {
+		t.Fatalf("Expected the session of the phone. Got '%s'", response.Body.String())
+	}
+
+	// Other users can't revoke the session
+	req, _ = http.NewRequest("DELETE", "/api/users/me/sessions/"+phoneSession.ID, nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me/sessions/"+phoneSession.ID, nil)
+	req.AddCookie(laptopToken)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// The access token of the phone is rejected before it expires, and it can't be refreshed
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(phoneToken)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/users/refresh", nil)
+	for _, cookie := range phone.Cookies() {
+		req.AddCookie(cookie)
+	}
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// The laptop stays logged in
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(laptopToken)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/users/me/sessions", nil)
+	req.AddCookie(laptopToken)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &result)
+	if result.Results != 1 || result.Sessions[0].UserAgent != "Laptop" {
+		t.Errorf("Expected only the session of the laptop. Got '%s'", response.Body.String())
+	}
+
+	// Logging out with only the access token ends the session
+	req, _ = http.NewRequest("POST", "/api/users/logout", nil)
+	req.AddCookie(laptopToken)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/users/refresh", nil)
+	for _, cookie := range laptop.Cookies() {
+		req.AddCookie(cookie)
+	}
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
@@ -3,35 +3,91 @@ package app
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+var (
+	errExerciseNotFound = errors.New("Exercise not found")
+	errWorkoutNotFound  = errors.New("Workout not found")
+)
+
 type set struct {
-	ID          int       `json:"id"`
-	UserID      string    `json:"userId"`
-	Weight      float64   `json:"weight" validate:"required"`
-	Exercise    string    `json:"exercise" validate:"required"`
-	Repetitions int       `json:"repetitions" validate:"required"`
-	Created     time.Time `json:"created"`
-	Modified    time.Time `json:"modified"`
+	ID         int     `json:"id"`
+	UserID     string  `json:"userId"`
+	WorkoutID  *int    `json:"workoutId"`
+	Weight     float64 `json:"weight" validate:"min=0"`
+	Unit       string  `json:"unit" validate:"omitempty,oneof=kg lb"`
+	ExerciseID int     `json:"exerciseId" validate:"required"`
+	Exercise   string  `json:"exercise"`
+	// Repetitions can be zero for failed attempts and timed sets, and the weight for bodyweight sets,
+	// but a set has at least one of them
+	Repetitions int `json:"repetitions" validate:"required_without_all=Weight DurationSeconds Distance,min=0,max=1000"`
+	// RPE is the rate of perceived exertion and RIR the number of repetitions in reserve
+	RPE *float64 `json:"rpe" validate:"omitempty,min=1,max=10"`
+	RIR *int     `json:"rir" validate:"omitempty,min=0,max=10"`
+	// Tempo is the duration of the phases of a repetition in seconds, e.g. "3-1-1-0" or "31X0"
+	Tempo           string `json:"tempo" validate:"omitempty,tempo"`
+	RestSeconds     *int   `json:"restSeconds" validate:"omitempty,min=0,max=3600"`
+	DurationSeconds *int   `json:"durationSeconds" validate:"omitempty,min=0,max=86400"`
+	// Distance is in meters
+	Distance *float64  `json:"distance" validate:"omitempty,min=0,max=1000000"`
+	SetType  string    `json:"setType" validate:"omitempty,oneof=warmup working drop failure amrap"`
+	Notes    string    `json:"notes" validate:"max=1000"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	// ImportKey identifies an imported set in the file it was imported from
+	ImportKey string `json:"-"`
+}
+
+// setColumns are the columns of sets, joined with the name of the exercise, in the order read by scan
+const setColumns = "s.id, s.user_id, s.workout_id, s.weight, s.exercise_id, e.name, s.repetitions, s.rpe, s.rir, s.tempo, s.rest_seconds, s.duration_seconds, s.distance, s.set_type, s.notes, s.created, s.modified"
+
+// tempoPattern matches the tempo of a set as four phases, optionally separated by dashes. X is an explosive phase.
+var tempoPattern = regexp.MustCompile(`^[0-9Xx](-?[0-9Xx]){3}$`)
+
+// setFilter contains the optional conditions and the order of listing sets
+type setFilter struct {
+	ExerciseID     *int       `json:"exerciseId"`
+	From           *time.Time `json:"from"`
+	To             *time.Time `json:"to"`
+	MinWeight      *float64   `json:"minWeight"`
+	MaxWeight      *float64   `json:"maxWeight"`
+	MinRepetitions *int       `json:"minRepetitions"`
+	MaxRepetitions *int       `json:"maxRepetitions"`
+	Sort           string     `json:"sort" validate:"oneof=created -created weight -weight repetitions -repetitions exercise -exercise"`
+}
+
+// setSortColumns maps the sortable fields of sets to database columns
+var setSortColumns = map[string]string{
+	"created":     "s.created",
+	"weight":      "s.weight",
+	"repetitions": "s.repetitions",
+	"exercise":    "e.name",
 }
 
 type sets struct {
-	Results int   `json:"results"`
-	Skip    int   `json:"skip"`
-	Limit   int   `json:"limit"`
-	Sets    []set `json:"sets"`
+	Results int    `json:"results"`
+	Total   *int   `json:"total,omitempty"`
+	Skip    int    `json:"skip"`
+	Limit   int    `json:"limit"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+	Sets    []set  `json:"sets"`
 }
 
 func (s *Server) handleGetSet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user information
-		claims, err := parseTokenCookie(w, r)
+		claims, err := parseToken(w, r)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -60,6 +116,14 @@ func (s *Server) handleGetSet() http.HandlerFunc {
 			return
 		}
 
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		set.fromKilograms(unit)
 		respondWithJSON(w, http.StatusOK, set)
 	}
 }
@@ -67,7 +131,7 @@ func (s *Server) handleGetSet() http.HandlerFunc {
 func (s *Server) handleGetSets() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user information
-		claims, err := parseTokenCookie(w, r)
+		claims, err := parseToken(w, r)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -79,21 +143,124 @@ func (s *Server) handleGetSets() http.HandlerFunc {
 		skip, _ := strconv.Atoi(r.FormValue("skip"))
 		limit, _ := strconv.Atoi(r.FormValue("limit"))
 
-		if limit > 10 || limit < 1 {
+		if limit < 1 {
 			limit = 10
 		}
+		if limit > s.MaxPageSize {
+			limit = s.MaxPageSize
+		}
 		if skip < 0 {
 			skip = 0
 		}
 
+		filter := setFilter{Sort: r.FormValue("sort")}
+		if filter.Sort == "" {
+			filter.Sort = "-created"
+		}
+		if filter.ExerciseID, err = parseIntParam(r, "exerciseId"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid exerciseId")
+			return
+		}
+		if filter.From, err = parseTimeParam(r, "from"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid from")
+			return
+		}
+		if filter.To, err = parseTimeParam(r, "to"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid to")
+			return
+		}
+		if filter.MinWeight, err = parseFloatParam(r, "minWeight"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid minWeight")
+			return
+		}
+		if filter.MaxWeight, err = parseFloatParam(r, "maxWeight"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid maxWeight")
+			return
+		}
+		if filter.MinRepetitions, err = parseIntParam(r, "minRepetitions"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid minRepetitions")
+			return
+		}
+		if filter.MaxRepetitions, err = parseIntParam(r, "maxRepetitions"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid maxRepetitions")
+			return
+		}
+
+		// Validate filter
+		err = s.Validator.Struct(filter)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Weights are given and returned in the unit of the user
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if filter.MinWeight != nil {
+			minWeight := toStoredKilograms(*filter.MinWeight, unit)
+			filter.MinWeight = &minWeight
+		}
+		if filter.MaxWeight != nil {
+			maxWeight := toStoredKilograms(*filter.MaxWeight, unit)
+			filter.MaxWeight = &maxWeight
+		}
+
+		// Cursors are positions in the order of creation, so they can't be used with other orders
+		sortedByCreated := strings.TrimPrefix(filter.Sort, "-") == "created"
+		var cursor *pageCursor
+		if r.FormValue("cursor") != "" {
+			cursor, err = decodeCursor(r.FormValue("cursor"))
+			if err != nil || !sortedByCreated || cursor.Sort != filter.Sort || skip > 0 {
+				respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+		}
+
+		// One extra set is fetched to find out if there are more sets after the page
 		sets := sets{Skip: skip, Limit: limit}
-		result, err := set.getSets(s.DB, skip, limit, claims.UserID)
+		result, err := set.getSets(s.DB, skip, limit+1, cursor, claims.UserID, filter)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
+		more := len(result) > limit
+		if more && cursor != nil && cursor.Backward {
+			result = result[1:]
+		} else if more {
+			result = result[:limit]
+		}
+
+		if len(result) > 0 && sortedByCreated {
+			first, last := result[0], result[len(result)-1]
+			backward := cursor != nil && cursor.Backward
+			if more || backward {
+				sets.Next = (&pageCursor{Created: last.Created, ID: last.ID, Sort: filter.Sort}).encode()
+			}
+			if (backward && more) || (!backward && (cursor != nil || skip > 0)) {
+				sets.Prev = (&pageCursor{Created: first.Created, ID: first.ID, Sort: filter.Sort, Backward: true}).encode()
+			}
+		}
+
+		if r.FormValue("total") == "true" {
+			total, err := set.countSets(s.DB, claims.UserID, filter)
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			sets.Total = &total
+		}
+
+		for i := range result {
+			result[i].fromKilograms(unit)
+		}
 		sets.Sets = result
 		sets.Results = len(result)
 		respondWithJSON(w, http.StatusOK, sets)
@@ -103,7 +270,7 @@ func (s *Server) handleGetSets() http.HandlerFunc {
 func (s *Server) handleCreateSet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user information
-		claims, err := parseTokenCookie(w, r)
+		claims, err := parseToken(w, r)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -128,20 +295,53 @@ func (s *Server) handleCreateSet() http.HandlerFunc {
 			return
 		}
 
+		// Check that the exercise and the workout belong to the user
+		if err := set.checkReferences(s.DB, claims.UserID); err != nil {
+			switch err {
+			case errExerciseNotFound, errWorkoutNotFound:
+				respondWithError(w, http.StatusBadRequest, err.Error())
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		// The weight is in the unit of the set or in the unit of the user
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		set.toKilograms(unit)
+
 		if err := set.createSet(s.DB, claims.UserID); err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
 			return
 		}
 
-		respondWithJSON(w, http.StatusCreated, set)
+		// Check which personal records the set beat
+		newRecords, err := set.getNewRecords(s.DB, claims.UserID, nil)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		set.fromKilograms(unit)
+		for i := range newRecords {
+			newRecords[i].fromKilograms(unit)
+		}
+		respondWithJSON(w, http.StatusCreated, setWithRecords{set: set, NewRecords: newRecords})
 	}
 }
 
 func (s *Server) handleUpdateSet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user information
-		claims, err := parseTokenCookie(w, r)
+		claims, err := parseToken(w, r)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -174,6 +374,35 @@ func (s *Server) handleUpdateSet() http.HandlerFunc {
 			return
 		}
 
+		// Check that the exercise and the workout belong to the user
+		if err := set.checkReferences(s.DB, claims.UserID); err != nil {
+			switch err {
+			case errExerciseNotFound, errWorkoutNotFound:
+				respondWithError(w, http.StatusBadRequest, err.Error())
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		// The weight is in the unit of the set or in the unit of the user
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		set.toKilograms(unit)
+
+		// The records held before the update aren't reported as new again
+		previousRecords, err := getRecords(s.DB, claims.UserID, set.ExerciseID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
 		set.ID = id
 		affectedRows, err := set.updateSet(s.DB, claims.UserID)
 		if err != nil {
@@ -187,7 +416,19 @@ func (s *Server) handleUpdateSet() http.HandlerFunc {
 			return
 		}
 
-		respondWithJSON(w, http.StatusOK, set)
+		// Check which personal records the updated set beat
+		newRecords, err := set.getNewRecords(s.DB, claims.UserID, previousRecords)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		set.fromKilograms(unit)
+		for i := range newRecords {
+			newRecords[i].fromKilograms(unit)
+		}
+		respondWithJSON(w, http.StatusOK, setWithRecords{set: set, NewRecords: newRecords})
 	}
 
 }
@@ -195,7 +436,7 @@ func (s *Server) handleUpdateSet() http.HandlerFunc {
 func (s *Server) handleDeleteSet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user information
-		claims, err := parseTokenCookie(w, r)
+		claims, err := parseToken(w, r)
 		if err != nil {
 			log.Println(err.Error())
 			respondWithError(w, http.StatusInternalServerError, "Internal server error")
@@ -227,15 +468,109 @@ func (s *Server) handleDeleteSet() http.HandlerFunc {
 	}
 }
 
-func (s *set) getSet(db *sql.DB, userID string) error {
-	return db.QueryRow("SELECT user_id, weight, exercise, repetitions, created, modified FROM sets WHERE id=$1 AND user_id=$2",
-		s.ID, userID).Scan(&s.UserID, &s.Weight, &s.Exercise, &s.Repetitions, &s.Created, &s.Modified)
+// checkReferences checks that the exercise of the set is in the catalog of the user and that the workout
+// of the set belongs to the user. Sets the name of the exercise to the set.
+func (s *set) checkReferences(db queryer, userID string) error {
+	exercise := exercise{ID: s.ExerciseID}
+	if err := exercise.getExercise(db, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return errExerciseNotFound
+		}
+		return err
+	}
+	s.Exercise = exercise.Name
+
+	if s.WorkoutID != nil {
+		workout := workout{ID: *s.WorkoutID}
+		exists, err := workout.checkIfWorkoutExists(db, userID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errWorkoutNotFound
+		}
+	}
+
+	return nil
+}
+
+func (s *set) getSet(db queryer, userID string) error {
+	return s.scan(db.QueryRow("SELECT "+setColumns+" FROM sets s JOIN exercises e ON e.id=s.exercise_id WHERE s.id=$1 AND s.user_id=$2",
+		s.ID, userID))
+}
+
+// scan reads a row of setColumns into the set
+func (s *set) scan(row scanner) error {
+	return row.Scan(&s.ID, &s.UserID, &s.WorkoutID, &s.Weight, &s.ExerciseID, &s.Exercise, &s.Repetitions, &s.RPE, &s.RIR, &s.Tempo,
+		&s.RestSeconds, &s.DurationSeconds, &s.Distance, &s.SetType, &s.Notes, &s.Created, &s.Modified)
+}
+
+// conditions returns the SQL conditions of the filter for listing the sets of the user. Conditions are
+// added only for the filters present and their values are passed as query parameters.
+func (f *setFilter) conditions(userID string) ([]string, []interface{}) {
+	conditions := []string{"s.user_id=$1"}
+	args := []interface{}{userID}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.ExerciseID != nil {
+		addCondition("s.exercise_id=$%d", *f.ExerciseID)
+	}
+	if f.From != nil {
+		addCondition("s.created>=$%d", *f.From)
+	}
+	if f.To != nil {
+		addCondition("s.created<$%d", *f.To)
+	}
+	if f.MinWeight != nil {
+		addCondition("s.weight>=$%d", *f.MinWeight)
+	}
+	if f.MaxWeight != nil {
+		addCondition("s.weight<=$%d", *f.MaxWeight)
+	}
+	if f.MinRepetitions != nil {
+		addCondition("s.repetitions>=$%d", *f.MinRepetitions)
+	}
+	if f.MaxRepetitions != nil {
+		addCondition("s.repetitions<=$%d", *f.MaxRepetitions)
+	}
+
+	return conditions, args
 }
 
-func (s *set) getSets(db *sql.DB, start, count int, userID string) ([]set, error) {
+// getSets returns a page of sets. With a cursor, the page starts after the position of the cursor
+// instead of skipping rows. Pages before a backward cursor are returned in the requested order.
+func (s *set) getSets(db *sql.DB, start, count int, c *pageCursor, userID string, filter setFilter) ([]set, error) {
+	conditions, args := filter.conditions(userID)
+
+	// Sort is validated against the sortable fields, a leading minus means descending order
+	descending := strings.HasPrefix(filter.Sort, "-")
+	sort := strings.TrimPrefix(filter.Sort, "-")
+
+	// Paging backward reverses the order of the query
+	if c != nil {
+		if c.Backward {
+			descending = !descending
+		}
+		operator := ">"
+		if descending {
+			operator = "<"
+		}
+		args = append(args, c.Created, c.ID)
+		conditions = append(conditions, fmt.Sprintf("(s.created, s.id) %s ($%d, $%d)", operator, len(args)-1, len(args)))
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	args = append(args, count, start)
 	rows, err := db.Query(
-		"SELECT id, user_id, weight, exercise, repetitions, created, modified FROM sets WHERE user_id=$1 ORDER BY created DESC LIMIT $2 OFFSET $3",
-		userID, count, start)
+		fmt.Sprintf("SELECT %s FROM sets s JOIN exercises e ON e.id=s.exercise_id WHERE %s ORDER BY %s %s, s.id %s LIMIT $%d OFFSET $%d",
+			setColumns, strings.Join(conditions, " AND "), setSortColumns[sort], direction, direction, len(args)-1, len(args)),
+		args...)
 	if err != nil {
 		return nil, err
 	}
@@ -244,19 +579,43 @@ func (s *set) getSets(db *sql.DB, start, count int, userID string) ([]set, error
 	sets := []set{}
 	for rows.Next() {
 		var s set
-		if err := rows.Scan(&s.ID, &s.UserID, &s.Weight, &s.Exercise, &s.Repetitions, &s.Created, &s.Modified); err != nil {
+		if err := s.scan(rows); err != nil {
 			return nil, err
 		}
 		sets = append(sets, s)
 	}
 
+	if c != nil && c.Backward {
+		for i, j := 0, len(sets)-1; i < j; i, j = i+1, j-1 {
+			sets[i], sets[j] = sets[j], sets[i]
+		}
+	}
+
 	return sets, nil
 }
 
-func (s *set) updateSet(db *sql.DB, userID string) (int64, error) {
+func (s *set) countSets(db *sql.DB, userID string, filter setFilter) (int, error) {
+	conditions, args := filter.conditions(userID)
+
+	count := 0
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(s.id) FROM sets s WHERE %s", strings.Join(conditions, " AND ")), args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *set) updateSet(db queryer, userID string) (int64, error) {
+	if s.SetType == "" {
+		s.SetType = "working"
+	}
 	result, err :=
-		db.Exec("UPDATE sets SET weight=$3, exercise=$4, repetitions=$5, modified=$6 WHERE id=$1 AND user_id=$2",
-			s.ID, userID, s.Weight, s.Exercise, s.Repetitions, time.Now())
+		db.Exec("UPDATE sets SET workout_id=$3, weight=$4, exercise_id=$5, repetitions=$6, rpe=$7, rir=$8, tempo=$9, rest_seconds=$10, duration_seconds=$11, distance=$12, set_type=$13, notes=$14, modified=$15 WHERE id=$1 AND user_id=$2",
+			s.ID, userID, s.WorkoutID, s.Weight, s.ExerciseID, s.Repetitions, s.RPE, s.RIR, s.Tempo, s.RestSeconds, s.DurationSeconds, s.Distance, s.SetType, s.Notes, time.Now())
+	if err != nil {
+		return 0, err
+	}
 
 	affected, err := result.RowsAffected()
 	if err != nil {
@@ -266,7 +625,7 @@ func (s *set) updateSet(db *sql.DB, userID string) (int64, error) {
 	return affected, err
 }
 
-func (s *set) deleteSet(db *sql.DB, userID string) (int64, error) {
+func (s *set) deleteSet(db queryer, userID string) (int64, error) {
 	result, err := db.Exec("DELETE FROM sets WHERE id=$1 and user_id=$2", s.ID, userID)
 	if err != nil {
 		return 0, err
@@ -280,11 +639,30 @@ func (s *set) deleteSet(db *sql.DB, userID string) (int64, error) {
 	return affected, err
 }
 
-func (s *set) createSet(db *sql.DB, userID string) error {
+// createSet inserts the set, created now
+func (s *set) createSet(db queryer, userID string) error {
+	return s.insertSet(db, userID, time.Time{})
+}
+
+// createImportedSet inserts the set with the creation time given by the import, so that imported sets
+// keep their original time. Sets without a time are created now.
+func (s *set) createImportedSet(db queryer, userID string) error {
+	return s.insertSet(db, userID, s.Created)
+}
+
+func (s *set) insertSet(db queryer, userID string, created time.Time) error {
 	current := time.Now()
+	if created.IsZero() {
+		created = current
+	}
+	if s.SetType == "" {
+		s.SetType = "working"
+	}
 	err := db.QueryRow(
-		"INSERT INTO sets(user_id, weight, exercise, repetitions, created, modified) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, created, modified",
-		userID, s.Weight, s.Exercise, s.Repetitions, current, current).Scan(&s.ID, &s.Created, &s.Modified)
+		`INSERT INTO sets(user_id, workout_id, weight, exercise_id, repetitions, rpe, rir, tempo, rest_seconds, duration_seconds, distance, set_type, notes, import_key, created, modified)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), $15, $16) RETURNING id, created, modified`,
+		userID, s.WorkoutID, s.Weight, s.ExerciseID, s.Repetitions, s.RPE, s.RIR, s.Tempo, s.RestSeconds, s.DurationSeconds, s.Distance, s.SetType, s.Notes,
+		s.ImportKey, created, current).Scan(&s.ID, &s.Created, &s.Modified)
 
 	if err != nil {
 		return err
@@ -292,3 +670,17 @@ func (s *set) createSet(db *sql.DB, userID string) error {
 
 	return nil
 }
+
+// checkIfImported checks if the user has already imported the set with the same import key, or if the
+// set was exported from the same account
+func (s *set) checkIfImported(db queryer, userID string) (bool, error) {
+	count := 0
+	id, created := parseExportKey("set", s.ImportKey)
+	err := db.QueryRow("SELECT COUNT(id) FROM sets WHERE user_id=$1 AND (import_key=$2 OR (id=$3 AND created=$4))",
+		userID, s.ImportKey, id, created).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
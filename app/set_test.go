@@ -129,12 +129,118 @@ func TestSkipAndLimit(t *testing.T) {
 
 }
 
+func TestFilterAndSortSets(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+
+	addSetAt(userIDs[0], "Squat", 100, 5, time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+	addSetAt(userIDs[0], "Squat", 120, 3, time.Date(2021, 2, 4, 10, 0, 0, 0, time.UTC))
+	addSetAt(userIDs[0], "Squat", 110, 8, time.Date(2021, 2, 5, 10, 0, 0, 0, time.UTC))
+	addSetAt(userIDs[0], "Bench press", 80, 5, time.Date(2021, 2, 5, 10, 0, 0, 0, time.UTC))
+
+	// Squats of February with at least 110 kg, heaviest first
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/sets?exerciseId=%d&from=2021-02-01T00:00:00Z&to=2021-03-01T00:00:00Z&minWeight=110&sort=-weight", getExerciseID("Squat")), nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m sets
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m.Results != 2 {
+		t.Fatalf("Expected results to be '2'. Got '%v'", m.Results)
+	}
+
+	if m.Sets[0].Weight != 120 || m.Sets[1].Weight != 110 {
+		t.Errorf("Expected weights '120' and '110'. Got '%v' and '%v'", m.Sets[0].Weight, m.Sets[1].Weight)
+	}
+
+	// Repetitions between 4 and 6, sorted by repetitions
+	req, _ = http.NewRequest("GET", "/api/v1/sets?minRepetitions=4&maxRepetitions=6&sort=repetitions", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m.Results != 2 {
+		t.Errorf("Expected results to be '2'. Got '%v'", m.Results)
+	}
+
+	// Sorting by a column that is not allowed
+	req, _ = http.NewRequest("GET", "/api/v1/sets?sort=user_id", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// Invalid date
+	req, _ = http.NewRequest("GET", "/api/v1/sets?from=yesterday", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestCursorPagination(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		addSetAt(userIDs[0], "Squat", float64(100+i), 5, start.Add(time.Duration(i)*time.Hour))
+	}
+
+	getPage := func(query string) sets {
+		req, _ := http.NewRequest("GET", "/api/v1/sets?"+query, nil)
+		req.AddCookie(cookie)
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+
+		var m sets
+		json.Unmarshal(response.Body.Bytes(), &m)
+		return m
+	}
+
+	// First page has the newest sets and no previous page
+	first := getPage("limit=2&total=true")
+	if first.Results != 2 || first.Sets[0].Weight != 104 || first.Next == "" || first.Prev != "" {
+		t.Fatalf("Unexpected first page. Got '%+v'", first)
+	}
+	if first.Total == nil || *first.Total != 5 {
+		t.Errorf("Expected total to be '5'. Got '%v'", first.Total)
+	}
+
+	// A set created while paging doesn't shift the following pages
+	addSetAt(userIDs[0], "Squat", 200, 5, start.Add(10*time.Hour))
+
+	second := getPage("limit=2&cursor=" + first.Next)
+	if second.Results != 2 || second.Sets[0].Weight != 102 || second.Sets[1].Weight != 101 {
+		t.Fatalf("Unexpected second page. Got '%+v'", second)
+	}
+
+	last := getPage("limit=2&cursor=" + second.Next)
+	if last.Results != 1 || last.Sets[0].Weight != 100 || last.Next != "" {
+		t.Fatalf("Unexpected last page. Got '%+v'", last)
+	}
+
+	// Previous page of the last page is the second page
+	previous := getPage("limit=2&cursor=" + last.Prev)
+	if previous.Results != 2 || previous.Sets[0].Weight != 102 || previous.Sets[1].Weight != 101 {
+		t.Errorf("Unexpected previous page. Got '%+v'", previous)
+	}
+
+	// Cursor can't be used with another order
+	req, _ := http.NewRequest("GET", "/api/v1/sets?sort=weight&cursor="+first.Next, nil)
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
 func TestCreateSet(t *testing.T) {
 	clearTables()
 	createTestUsers()
 
 	// Valid request
-	var jsonStr1 = []byte(`{"weight": 111.22, "exercise":"squat", "repetitions":10}`)
+	var jsonStr1 = []byte(fmt.Sprintf(`{"weight": 111.22, "exerciseId":%d, "repetitions":10}`, getExerciseID("Squat")))
 	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr1))
 	req.AddCookie(authenticate("user1@localhost.com", "password1"))
 	req.Header.Set("Content-Type", "application/json")
@@ -149,8 +255,8 @@ func TestCreateSet(t *testing.T) {
 		t.Errorf("Expected weight to be '11.22'. Got '%v'", m["weight"])
 	}
 
-	if m["exercise"] != "squat" {
-		t.Errorf("Expected exercise to be 'squat'. Got '%v'", m["exercise"])
+	if m["exercise"] != "Squat" {
+		t.Errorf("Expected exercise to be 'Squat'. Got '%v'", m["exercise"])
 	}
 
 	// Numbers are compared to floats because JSON unmarshaling converts numbers to
@@ -163,14 +269,97 @@ func TestCreateSet(t *testing.T) {
 		t.Errorf("Expected set ID to be '1'. Got '%v'", m["id"])
 	}
 
-	// Invalid request
-	var jsonStr2 = []byte(`{"exercise":"squat", "repetitions":10}`)
+	// Invalid request, neither weight nor repetitions
+	var jsonStr2 = []byte(fmt.Sprintf(`{"exerciseId":%d}`, getExerciseID("Squat")))
 	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr2))
 	req.AddCookie(authenticate("user1@localhost.com", "password1"))
 	req.Header.Set("Content-Type", "application/json")
 
 	response = executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// Unknown exercise
+	var jsonStr3 = []byte(`{"weight": 111.22, "exerciseId":999999, "repetitions":10}`)
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr3))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestCreateSetDetails(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Details are optional and returned as they were given
+	var jsonStr1 = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5, "rpe":8.5, "rir":1, "tempo":"3-1-X-0", "restSeconds":180, "setType":"amrap", "notes":"Felt good"}`, getExerciseID("Squat")))
+	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr1))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/v1/sets/1", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+
+	var m set
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m.RPE == nil || *m.RPE != 8.5 || m.RIR == nil || *m.RIR != 1 || m.Tempo != "3-1-X-0" || m.SetType != "amrap" || m.Notes != "Felt good" {
+		t.Errorf("Expected the details of the set. Got '%+v'", m)
+	}
+
+	// Failed attempts and timed sets don't need repetitions
+	var jsonStr2 = []byte(fmt.Sprintf(`{"weight": 150, "exerciseId":%d, "repetitions":0, "setType":"failure"}`, getExerciseID("Squat")))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr2))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var jsonStr3 = []byte(fmt.Sprintf(`{"exerciseId":%d, "durationSeconds":60}`, getExerciseID("Deadlift")))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr3))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m.SetType != "working" {
+		t.Errorf("Expected set type to be 'working'. Got '%s'", m.SetType)
+	}
+
+	// The creation time is set by the server
+	var jsonStr4 = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5, "created":"2001-01-01T00:00:00Z"}`, getExerciseID("Squat")))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr4))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if time.Since(m.Created) > time.Minute {
+		t.Errorf("Expected the set to be created now. Got '%v'", m.Created)
+	}
+
+	// Bodyweight sets don't need weight
+	var jsonStr5 = []byte(fmt.Sprintf(`{"weight": 0, "exerciseId":%d, "repetitions":10}`, getExerciseID("Squat")))
+	req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr5))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	// Invalid details
+	for _, details := range []string{`"rpe":11`, `"rir":-1`, `"tempo":"slow"`, `"setType":"heavy"`, `"restSeconds":-10`} {
+		var jsonStr = []byte(fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5, %s}`, getExerciseID("Squat"), details))
+		req, _ = http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+		req.AddCookie(authenticate("user1@localhost.com", "password1"))
+		req.Header.Set("Content-Type", "application/json")
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusBadRequest, response.Code)
+	}
 }
 
 func TestUpdateSet(t *testing.T) {
@@ -184,7 +373,7 @@ func TestUpdateSet(t *testing.T) {
 	var originalSet map[string]interface{}
 	json.Unmarshal(response.Body.Bytes(), &originalSet)
 
-	var jsonStr = []byte(`{"weight": 222.22, "exercise":"bench", "repetitions":15}`)
+	var jsonStr = []byte(fmt.Sprintf(`{"weight": 222.22, "exerciseId":%d, "repetitions":15}`, getExerciseID("Bench press")))
 	req, _ = http.NewRequest("PUT", "/api/v1/sets/1", bytes.NewBuffer(jsonStr))
 	req.AddCookie(authenticate("user1@localhost.com", "password1"))
 	req.Header.Set("Content-Type", "application/json")
@@ -218,8 +407,8 @@ func TestUpdateSet(t *testing.T) {
 		t.Errorf("Expected set ID to be '1'. Got '%v'", m["id"])
 	}
 
-	// Invalid request
-	var jsonStr2 = []byte(`{"exercise":"squat", "repetitions":10}`)
+	// Invalid request, neither weight nor repetitions
+	var jsonStr2 = []byte(fmt.Sprintf(`{"exerciseId":%d}`, getExerciseID("Squat")))
 	req, _ = http.NewRequest("PUT", "/api/v1/sets/1", bytes.NewBuffer(jsonStr2))
 	req.AddCookie(authenticate("user1@localhost.com", "password1"))
 	req.Header.Set("Content-Type", "application/json")
@@ -272,10 +461,19 @@ func addSets(userIDs []string) {
 
 	current := time.Now()
 	for _, userID := range userIDs {
-		_, err := testServer.DB.Exec("INSERT INTO sets(user_id, weight, exercise, repetitions, created, modified) VALUES($1, $2, $3, $4, $5, $6)", userID, (rand.Intn(5)+1.0)*10, "squat", rand.Intn(5)*2, current, current)
+		_, err := testServer.DB.Exec("INSERT INTO sets(user_id, weight, exercise_id, repetitions, created, modified) VALUES($1, $2, $3, $4, $5, $6)", userID, (rand.Intn(5)+1.0)*10, getExerciseID("Squat"), rand.Intn(5)*2, current, current)
 		if err != nil {
 			log.Fatal(err.Error())
 			break
 		}
 	}
 }
+
+func getExerciseID(name string) int {
+	var id int
+	err := testServer.DB.QueryRow("SELECT id FROM exercises WHERE user_id IS NULL AND name=$1", name).Scan(&id)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	return id
+}
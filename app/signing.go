@@ -0,0 +1,231 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingKey is a key for signing access tokens or verifying them. Private is nil for the keys that only
+// verify the tokens signed before the key was rotated.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// tokenKeySet is the key that signs new access tokens and the keys that verify them by their kid header.
+// The HS256 secret verifies the tokens signed before switching to asymmetric keys, as long as it's configured.
+type tokenKeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	secret  []byte
+}
+
+// tokenKeys signs and verifies the access tokens. It's a package variable like the configuration of
+// the tokens was before, since readClaims is used without the server.
+var tokenKeys = newSecretKeySet([]byte(os.Getenv("JWT_KEY")))
+
+// newSecretKeySet returns a key set signing with the shared secret JWT_KEY, which other services need for verifying the tokens
+func newSecretKeySet(secret []byte) *tokenKeySet {
+	return &tokenKeySet{
+		signing: &signingKey{Method: jwt.SigningMethodHS256, Private: secret, Public: secret},
+		keys:    map[string]*signingKey{},
+		secret:  secret,
+	}
+}
+
+// loadTokenKeys reads the keys from the PEM files of JWT_KEYS_DIR, where the name of the file without ".pem" is the kid
+// of the key. JWT_SIGNING_KEY_ID selects the key signing new tokens, and the other keys only verify tokens.
+// Without JWT_KEYS_DIR, tokens are signed with the secret JWT_KEY.
+func loadTokenKeys() (*tokenKeySet, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	secret := os.Getenv("JWT_KEY")
+	if dir == "" {
+		if secret == "" {
+			return nil, errors.New("JWT_KEYS_DIR or JWT_KEY not set")
+		}
+		return newSecretKeySet([]byte(secret)), nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keySet := &tokenKeySet{keys: map[string]*signingKey{}}
+	if secret != "" {
+		keySet.secret = []byte(secret)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseSigningKey(strings.TrimSuffix(filepath.Base(file), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		keySet.keys[key.ID] = key
+	}
+
+	signingID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingID == "" && len(keySet.keys) == 1 {
+		for id := range keySet.keys {
+			signingID = id
+		}
+	}
+	signing, ok := keySet.keys[signingID]
+	if !ok || signing.Private == nil {
+		return nil, fmt.Errorf("no private key %s.pem in %s for signing, set JWT_SIGNING_KEY_ID", signingID, dir)
+	}
+	keySet.signing = signing
+	return keySet, nil
+}
+
+// parseSigningKey parses an RSA or Ed25519 key, which is either a private key or the public key of a rotated key
+func parseSigningKey(id string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{ID: id, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &signingKey{ID: id, Method: jwt.SigningMethodRS256, Public: key}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{ID: id, Method: signingMethodEdDSA, Private: key, Public: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{ID: id, Method: signingMethodEdDSA, Public: key}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", parsed)
+}
+
+// sign signs the claims with the signing key and names the key in the kid header
+func (k *tokenKeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	if k.signing.ID != "" {
+		token.Header["kid"] = k.signing.ID
+	}
+	return token.SignedString(k.signing.Private)
+}
+
+// verificationKey returns the key for verifying the token. The key is chosen by the kid and the algorithm must
+// match the key, so that a public key is never used as an HS256 secret.
+func (k *tokenKeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if k.secret == nil {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return k.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	if key.Method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+	}
+	return key.Public, nil
+}
+
+// jsonWebKeys returns the public keys in the JSON Web Key Set format, sorted by kid
+func (k *tokenKeySet) jsonWebKeys() []jsonWebKey {
+	keys := []jsonWebKey{}
+	for _, key := range k.keys {
+		jwk := jsonWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
+
+// handleJWKS publishes the public keys, so that other services can verify the access tokens without a shared secret
+func (s *Server) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verifiers may cache the keys for a while, since a new key is added before it signs any tokens
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		respondWithJSON(w, http.StatusOK, map[string][]jsonWebKey{"keys": tokenKeys.jsonWebKeys()})
+	}
+}
+
+// signingMethodEdDSA signs tokens with Ed25519 keys as defined in RFC 8037, which jwt-go doesn't support
+var signingMethodEdDSA = &signingMethodEd25519{}
+
+type signingMethodEd25519 struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// writeKey writes the private key in PKCS #8 PEM format to the directory as <kid>.pem
+func writeKey(t *testing.T, dir, kid string, key interface{}) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useKeys loads the keys of the directory as if the application was restarted with JWT_SIGNING_KEY_ID
+func useKeys(t *testing.T, dir, signingID string) {
+	os.Setenv("JWT_KEYS_DIR", dir)
+	os.Setenv("JWT_SIGNING_KEY_ID", signingID)
+	defer os.Unsetenv("JWT_KEYS_DIR")
+	defer os.Unsetenv("JWT_SIGNING_KEY_ID")
+
+	keySet, err := loadTokenKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenKeys = keySet
+}
+
+func tokenHeader(t *testing.T, cookie *http.Cookie) map[string]interface{} {
+	token, _, err := new(jwt.Parser).ParseUnverified(cookie.Value, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Header
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	original := tokenKeys
+	defer func() { tokenKeys = original }()
+
+	// A token signed with the shared secret before moving to asymmetric keys
+	secretCookie := authenticate("user1@localhost.com", "password1")
+
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "rsa-1", rsaKey)
+	useKeys(t, dir, "rsa-1")
+
+	rsaCookie := authenticate("user1@localhost.com", "password1")
+	if header := tokenHeader(t, rsaCookie); header["alg"] != "RS256" || header["kid"] != "rsa-1" {
+		t.Errorf("Expected an RS256 token of rsa-1. Got '%v'", header)
+	}
+
+	// Tokens of the shared secret are still accepted while JWT_KEY is set
+	req, _ := http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(secretCookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// The new key is published before it signs tokens
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "ed-2", edKey)
+	useKeys(t, dir, "ed-2")
+
+	req, _ = http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	json.Unmarshal(response.Body.Bytes(), &keySet)
+	if len(keySet.Keys) != 2 || keySet.Keys[0].KeyID != "ed-2" || keySet.Keys[0].KeyType != "OKP" || keySet.Keys[1].Algorithm != "RS256" {
+		t.Errorf("Expected the keys ed-2 and rsa-1. Got '%s'", response.Body.String())
+	}
+	if strings.Contains(response.Body.String(), `"d"`) {
+		t.Error("Expected no private keys in the key set")
+	}
+
+	edCookie := authenticate("user1@localhost.com", "password1")
+	if header := tokenHeader(t, edCookie); header["alg"] != "EdDSA" || header["kid"] != "ed-2" {
+		t.Errorf("Expected an EdDSA token of ed-2. Got '%v'", header)
+	}
+
+	// Tokens of the rotated key are accepted until the key is removed
+	for _, cookie := range []*http.Cookie{rsaCookie, edCookie} {
+		req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+		req.AddCookie(cookie)
+		response = executeRequest(req)
+		checkResponseCode(t, http.StatusOK, response.Code)
+	}
+
+	os.Remove(filepath.Join(dir, "rsa-1.pem"))
+	useKeys(t, dir, "ed-2")
+	req, _ = http.NewRequest("GET", "/api/heartbeat", nil)
+	req.AddCookie(rsaCookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+}
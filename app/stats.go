@@ -0,0 +1,135 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// oneRepMaxFormulas maps the supported formulas for estimating one-rep-max to SQL expressions
+var oneRepMaxFormulas = map[string]string{
+	"epley":    "CASE WHEN s.repetitions=1 THEN s.weight ELSE s.weight * (1 + s.repetitions / 30.0) END",
+	"brzycki":  "CASE WHEN s.repetitions < 37 THEN s.weight * 36 / (37 - s.repetitions) END",
+	"lombardi": "s.weight * POWER(s.repetitions, 0.10)",
+}
+
+// statsQuery contains the parameters of aggregating the sets of a user
+type statsQuery struct {
+	Bucket     string `json:"bucket" validate:"oneof=day week month"`
+	Formula    string `json:"formula" validate:"oneof=epley brzycki lombardi"`
+	ExerciseID int    `json:"exerciseId"`
+	From       *time.Time
+	To         *time.Time
+}
+
+// stat contains the aggregated sets of an exercise during a time bucket
+type stat struct {
+	ExerciseID   int       `json:"exerciseId"`
+	Exercise     string    `json:"exercise"`
+	Period       time.Time `json:"period"`
+	Sets         int       `json:"sets"`
+	Volume       float64   `json:"volume"`
+	MaxWeight    float64   `json:"maxWeight"`
+	Estimated1RM *float64  `json:"estimated1RM"`
+}
+
+type stats struct {
+	Unit    string `json:"unit"`
+	Bucket  string `json:"bucket"`
+	Formula string `json:"formula"`
+	Results int    `json:"results"`
+	Stats   []stat `json:"stats"`
+}
+
+func (s *Server) handleGetStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		query := statsQuery{Bucket: r.FormValue("bucket"), Formula: r.FormValue("formula")}
+		if query.Bucket == "" {
+			query.Bucket = "week"
+		}
+		if query.Formula == "" {
+			query.Formula = "epley"
+		}
+		if r.FormValue("exerciseId") != "" {
+			query.ExerciseID, err = strconv.Atoi(r.FormValue("exerciseId"))
+			if err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusBadRequest, "Invalid exercise ID")
+				return
+			}
+		}
+		if query.From, err = parseTimeParam(r, "from"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid from")
+			return
+		}
+		if query.To, err = parseTimeParam(r, "to"); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid to")
+			return
+		}
+
+		// Validate query
+		err = s.Validator.Struct(query)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		result, err := query.getStats(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		for i := range result {
+			result[i].fromKilograms(unit)
+		}
+		respondWithJSON(w, http.StatusOK, stats{Unit: unit, Bucket: query.Bucket, Formula: query.Formula, Results: len(result), Stats: result})
+	}
+}
+
+// getStats aggregates the sets of the user by exercise and time bucket in the database
+func (q *statsQuery) getStats(db *sql.DB, userID string) ([]stat, error) {
+	rows, err := db.Query(fmt.Sprintf(
+		`SELECT s.exercise_id, e.name, date_trunc($2, s.created) AS period, COUNT(s.id), SUM(s.weight * COALESCE(s.repetitions, 0)), MAX(s.weight), ROUND(MAX(CASE WHEN s.repetitions > 0 THEN %s END), 2)
+		FROM sets s JOIN exercises e ON e.id=s.exercise_id
+		WHERE s.user_id=$1 AND ($3=0 OR s.exercise_id=$3) AND ($4::timestamptz IS NULL OR s.created >= $4) AND ($5::timestamptz IS NULL OR s.created < $5)
+		GROUP BY s.exercise_id, e.name, period
+		ORDER BY period ASC, e.name ASC`, oneRepMaxFormulas[q.Formula]),
+		userID, q.Bucket, q.ExerciseID, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []stat{}
+	for rows.Next() {
+		var s stat
+		if err := rows.Scan(&s.ExerciseID, &s.Exercise, &s.Period, &s.Sets, &s.Volume, &s.MaxWeight, &s.Estimated1RM); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetStats(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+
+	day1 := time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC)
+	addSetAt(userIDs[0], "Squat", 100, 5, day1)
+	addSetAt(userIDs[0], "Squat", 110, 3, day1)
+	addSetAt(userIDs[0], "Squat", 120, 1, day2)
+	addSetAt(userIDs[1], "Squat", 200, 1, day2)
+
+	req, _ := http.NewRequest("GET", "/api/v1/stats?bucket=week&formula=brzycki", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m stats
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m.Results != 1 {
+		t.Fatalf("Expected results to be '1'. Got '%v'", m.Results)
+	}
+
+	stat := m.Stats[0]
+	if stat.Sets != 3 {
+		t.Errorf("Expected sets to be '3'. Got '%v'", stat.Sets)
+	}
+
+	if stat.Volume != 950 {
+		t.Errorf("Expected volume to be '950'. Got '%v'", stat.Volume)
+	}
+
+	if stat.MaxWeight != 120 {
+		t.Errorf("Expected max weight to be '120'. Got '%v'", stat.MaxWeight)
+	}
+
+	// Brzycki gives 112.5 for 100x5, 116.47 for 110x3 and 120 for 120x1
+	if stat.Estimated1RM == nil || *stat.Estimated1RM != 120 {
+		t.Errorf("Expected estimated 1RM to be '120'. Got '%v'", stat.Estimated1RM)
+	}
+
+	// Daily buckets
+	req, _ = http.NewRequest("GET", "/api/v1/stats?bucket=day", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	json.Unmarshal(response.Body.Bytes(), &m)
+	if m.Results != 2 {
+		t.Errorf("Expected results to be '2'. Got '%v'", m.Results)
+	}
+
+	// Unknown formula
+	req, _ = http.NewRequest("GET", "/api/v1/stats?formula=guess", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func addSetAt(userID, exercise string, weight float64, repetitions int, created time.Time) {
+	_, err := testServer.DB.Exec("INSERT INTO sets(user_id, weight, exercise_id, repetitions, created, modified) VALUES($1, $2, $3, $4, $5, $6)", userID, weight, getExerciseID(exercise), repetitions, created, created)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
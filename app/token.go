@@ -0,0 +1,119 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// refreshToken is a long-lived token for getting new access tokens. Only the hash of the token is stored.
+// Every refresh replaces the token with a new token of the same family. A token used twice means that
+// someone else has a copy of it, so the whole family is revoked.
+type refreshToken struct {
+	Hash     string
+	FamilyID string
+	UserID   string
+	Username string
+	Expires  time.Time
+	Used     bool
+	Revoked  bool
+	Created  time.Time
+}
+
+// newRandomToken returns a random token suitable for storing as a hash
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the stored presentation of a random token. Tokens have enough entropy,
+// so a fast hash is sufficient, unlike with passwords.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (t *refreshToken) createRefreshToken(db queryer) error {
+	_, err := db.Exec(
+		"INSERT INTO refresh_tokens(token_hash, family_id, user_id, expires, used, revoked, created) VALUES($1, $2, $3, $4, false, false, $5)",
+		t.Hash, t.FamilyID, t.UserID, t.Expires, t.Created)
+
+	return err
+}
+
+// getRefreshToken finds a refresh token by its value. The token is locked until the end of the transaction,
+// so that concurrent refreshes with the same token can't both succeed.
+func (t *refreshToken) getRefreshToken(db queryer, token string) error {
+	return db.QueryRow(
+		"SELECT t.token_hash, t.family_id, t.user_id, u.username, t.expires, t.used, t.revoked, t.created FROM refresh_tokens t JOIN users u ON u.user_id=t.user_id WHERE t.token_hash=$1 FOR UPDATE OF t",
+		hashToken(token)).Scan(&t.Hash, &t.FamilyID, &t.UserID, &t.Username, &t.Expires, &t.Used, &t.Revoked, &t.Created)
+}
+
+func (t *refreshToken) markRefreshTokenUsed(db queryer) error {
+	_, err := db.Exec("UPDATE refresh_tokens SET used=true WHERE token_hash=$1", t.Hash)
+	return err
+}
+
+// revokeTokenFamily revokes all the refresh tokens descending from the same login and the session of the login
+func revokeTokenFamily(db queryer, familyID string) error {
+	if _, err := db.Exec("UPDATE refresh_tokens SET revoked=true WHERE family_id=$1", familyID); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE sessions SET revoked=true WHERE id=$1", familyID)
+	return err
+}
+
+// revokeAccessToken adds an access token to the revocation store until the token expires
+func revokeAccessToken(db queryer, claims *Claims) error {
+	_, err := db.Exec(
+		"INSERT INTO revoked_tokens(jti, user_id, expires, created) VALUES($1, $2, $3, $4) ON CONFLICT (jti) DO NOTHING",
+		claims.Id, claims.UserID, time.Unix(claims.ExpiresAt, 0), time.Now())
+
+	return err
+}
+
+// revokeUserTokens invalidates every token issued to the user before the given time. Refresh tokens are
+// revoked directly and access tokens by their issue time.
+func revokeUserTokens(db queryer, userID string, before time.Time) error {
+	if _, err := db.Exec("UPDATE users SET tokens_valid_after=$2, modified=$3 WHERE user_id=$1", userID, before, time.Now()); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE refresh_tokens SET revoked=true WHERE user_id=$1 AND created < $2", userID, before); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE sessions SET revoked=true WHERE user_id=$1 AND created < $2", userID, before)
+	return err
+}
+
+// checkIfTokenRevoked checks if the access token has been revoked either by its ID, by its issue time or by its session.
+// Issue times have a precision of seconds, so tokens issued during the second of logging out everywhere are revoked too.
+// Tokens issued before sessions were recorded have no session, and a session that has been deleted is revoked.
+func checkIfTokenRevoked(db queryer, claims *Claims) (bool, error) {
+	revoked := false
+	sessionRevoked := false
+	var validAfter sql.NullTime
+	err := db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti=$1), (SELECT tokens_valid_after FROM users WHERE user_id=$2),
+		$3 <> '' AND NOT EXISTS (SELECT 1 FROM sessions WHERE id=$3 AND NOT revoked)`,
+		claims.Id, claims.UserID, claims.SessionID).Scan(&revoked, &validAfter, &sessionRevoked)
+	if err != nil {
+		return true, err
+	}
+
+	if validAfter.Valid && claims.IssuedAt <= validAfter.Time.Unix() {
+		return true, nil
+	}
+	return revoked || sessionRevoked, nil
+}
+
+// deleteExpiredRevocations removes the revoked access tokens that have expired anyway
+func deleteExpiredRevocations(db queryer) error {
+	_, err := db.Exec("DELETE FROM revoked_tokens WHERE expires < $1", time.Now())
+	return err
+}
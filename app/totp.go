@@ -0,0 +1,574 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP codes are computed as defined in RFC 6238 with the defaults of authenticator apps:
+// HMAC-SHA1, six digits and a step of 30 seconds
+const (
+	totpIssuer = "Gymlog"
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is the number of steps before and after the current one that are accepted, so that small
+	// differences between the clocks of the server and the phone don't matter
+	totpSkew = 1
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes created when two-factor authentication is enabled
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the number of random bytes of a recovery code. 80 bits are enough for storing
+	// the codes with a fast hash like other tokens.
+	recoveryCodeBytes = 10
+	// loginChallengeTTL is the time the user has for entering the code after entering the password
+	loginChallengeTTL = 5 * time.Minute
+	// loginChallengeAttempts is the number of wrong codes after which the user has to enter the password again
+	loginChallengeAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpEnrollment is the secret of a user enabling two-factor authentication. The URI is shown as a QR code
+// for authenticator apps, the secret can be entered manually.
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type confirmTOTP struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type disableTOTP struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// recoveryCodes are shown to the user only once, when two-factor authentication is enabled
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// loginChallengeResponse is the response of logging in with the password when the user has two-factor
+// authentication enabled. The challenge is sent back with the code for finishing the login.
+type loginChallengeResponse struct {
+	Result    string `json:"result"`
+	Challenge string `json:"challenge"`
+}
+
+// loginTOTP is the second step of logging in, with either a code of the authenticator app or a recovery code
+type loginTOTP struct {
+	Challenge    string `json:"challenge" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// totp is the two-factor authentication state of a user. Secret is empty until enrollment has started,
+// and Enabled is false until the user has confirmed the enrollment with a code.
+type totp struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// loginChallenge is the proof of a correct password while the user enters the code. Only the hash of
+// the challenge is stored.
+type loginChallenge struct {
+	Hash     string
+	UserID   string
+	Expires  time.Time
+	Attempts int
+	Used     bool
+	Created  time.Time
+}
+
+func (s *Server) handleEnrollTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var totp totp
+		if err := totp.getTOTP(s.DB, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if totp.Enabled {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication already enabled")
+			return
+		}
+
+		// Starting the enrollment again replaces the secret, in case the user lost it before confirming
+		secret := make([]byte, 20)
+		if _, err := rand.Read(secret); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		totp.Secret = totpEncoding.EncodeToString(secret)
+		if err := totp.startEnrollment(s.DB, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, totpEnrollment{Secret: totp.Secret, URI: totpURI(totp.Secret, claims.Username)})
+	}
+}
+
+func (s *Server) handleConfirmTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request confirmTOTP
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		var totp totp
+		if err := totp.getTOTP(tx, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if totp.Enabled {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication already enabled")
+			return
+		}
+		if totp.Secret == "" {
+			respondWithError(w, http.StatusBadRequest, "Two-factor authentication enrollment not started")
+			return
+		}
+
+		// The first code proves that the authenticator app has the secret
+		if !totp.verify(request.Code, time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "Invalid code")
+			return
+		}
+		if err := totp.enable(tx, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		codes, err := createRecoveryCodes(tx, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+func (s *Server) handleDisableTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var request disableTOTP
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err = s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// The password is required, so that a stolen token isn't enough for weakening the account
+		user := user{UserID: claims.UserID}
+		if err := user.getUserByID(s.DB); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Invalid password")
+			return
+		}
+
+		if err := disableUserTOTP(s.DB, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleLoginTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request loginTOTP
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err := s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		var challenge loginChallenge
+		if err := challenge.getLoginChallenge(tx, request.Challenge); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+		if challenge.Used || challenge.Attempts >= loginChallengeAttempts || time.Now().After(challenge.Expires) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge")
+			return
+		}
+
+		user := user{UserID: challenge.UserID}
+		if err := user.getUserByID(tx); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if user.Enabled == 0 {
+			respondWithError(w, http.StatusForbidden, "Account disabled")
+			return
+		}
+		accountKey, ipKey := loginFailureKeys(user.Username, s.clientIP(r))
+		lockedUntil, err := getLoginLockout(tx, accountKey, ipKey)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if lockedUntil != nil {
+			respondWithLockout(w, *lockedUntil)
+			return
+		}
+
+		var totp totp
+		if err := totp.getTOTP(tx, challenge.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		valid := false
+		if request.Code != "" {
+			if valid = totp.Enabled && totp.verify(request.Code, time.Now()); valid {
+				var affectedRows int64
+				affectedRows, err = totp.updateLastStep(tx, challenge.UserID)
+				valid = affectedRows > 0
+			}
+		} else {
+			valid, err = useRecoveryCode(tx, challenge.UserID, request.RecoveryCode)
+		}
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Wrong codes are counted, so that the code can't be guessed with the same challenge or with new ones
+		if !valid {
+			if err := s.recordLoginFailures(tx, accountKey, ipKey); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if err := challenge.addAttempt(tx); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+				return
+			}
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+
+		if err := challenge.use(tx); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := clearLoginFailures(tx, accountKey); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Each login starts a new session with its own family of refresh tokens
+		familyID, err := uuid.NewRandom()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		tokens, err := s.createTokens(tx, r, user.UserID, user.Username, familyID.String())
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		tokens.setCookies(w)
+		respondWithJSON(w, http.StatusOK, tokens.response(r))
+	}
+}
+
+// createLoginChallenge creates a challenge for the second step of logging in and returns its value
+func createLoginChallenge(db queryer, userID string) (string, error) {
+	value, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	current := time.Now()
+	_, err = db.Exec(
+		"INSERT INTO login_challenges(challenge_hash, user_id, expires, attempts, used, created) VALUES($1, $2, $3, 0, false, $4)",
+		hashToken(value), userID, current.Add(loginChallengeTTL), current)
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// getLoginChallenge finds a challenge by its value and locks it until the end of the transaction
+func (c *loginChallenge) getLoginChallenge(db queryer, challenge string) error {
+	return db.QueryRow("SELECT challenge_hash, user_id, expires, attempts, used, created FROM login_challenges WHERE challenge_hash=$1 FOR UPDATE",
+		hashToken(challenge)).Scan(&c.Hash, &c.UserID, &c.Expires, &c.Attempts, &c.Used, &c.Created)
+}
+
+func (c *loginChallenge) addAttempt(db queryer) error {
+	_, err := db.Exec("UPDATE login_challenges SET attempts=attempts+1 WHERE challenge_hash=$1", c.Hash)
+	return err
+}
+
+func (c *loginChallenge) use(db queryer) error {
+	_, err := db.Exec("UPDATE login_challenges SET used=true WHERE challenge_hash=$1", c.Hash)
+	return err
+}
+
+func (t *totp) getTOTP(db queryer, userID string) error {
+	var secret sql.NullString
+	if err := db.QueryRow("SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE user_id=$1", userID).
+		Scan(&secret, &t.Enabled, &t.LastStep); err != nil {
+		return err
+	}
+	t.Secret = secret.String
+	return nil
+}
+
+func (t *totp) startEnrollment(db queryer, userID string) error {
+	_, err := db.Exec("UPDATE users SET totp_secret=$2, totp_enabled=false, totp_last_step=0, modified=$3 WHERE user_id=$1",
+		userID, t.Secret, time.Now())
+	return err
+}
+
+func (t *totp) enable(db queryer, userID string) error {
+	_, err := db.Exec("UPDATE users SET totp_enabled=true, totp_last_step=$2, modified=$3 WHERE user_id=$1",
+		userID, t.LastStep, time.Now())
+	return err
+}
+
+// updateLastStep stores the step of the accepted code, unless a concurrent login has already accepted the
+// same or a later step. Then no rows are affected and the code must be rejected.
+func (t *totp) updateLastStep(db queryer, userID string) (int64, error) {
+	result, err := db.Exec("UPDATE users SET totp_last_step=$2 WHERE user_id=$1 AND totp_last_step < $2", userID, t.LastStep)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// disableUserTOTP removes the secret and the recovery codes of the user
+func disableUserTOTP(db queryer, userID string) error {
+	if _, err := db.Exec("UPDATE users SET totp_secret=NULL, totp_enabled=false, totp_last_step=0, modified=$2 WHERE user_id=$1",
+		userID, time.Now()); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM totp_recovery_codes WHERE user_id=$1", userID)
+	return err
+}
+
+// verify checks the code against the steps around the given time. A code is accepted only for a step after
+// the last accepted one, so that an observed code can't be used again. LastStep is updated on success.
+func (t *totp) verify(code string, now time.Time) bool {
+	secret, err := totpEncoding.DecodeString(t.Secret)
+	if err != nil {
+		return false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= t.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			t.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the code of the given step as defined in RFC 4226
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// totpURI returns the provisioning URI of the secret in the format of Google Authenticator
+func totpURI(secret, username string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// createRecoveryCodes replaces the recovery codes of the user with new ones and returns them.
+// Only the hashes of the codes are stored.
+func createRecoveryCodes(db queryer, userID string) ([]string, error) {
+	if _, err := db.Exec("DELETE FROM totp_recovery_codes WHERE user_id=$1", userID); err != nil {
+		return nil, err
+	}
+
+	current := time.Now()
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+
+		if _, err := db.Exec("INSERT INTO totp_recovery_codes(code_hash, user_id, used, created) VALUES($1, $2, false, $3)",
+			hashToken(code), userID, current); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// useRecoveryCode marks the recovery code of the user used and returns whether it was valid. The code is
+// accepted in any case and with or without the dash.
+func useRecoveryCode(db queryer, userID, code string) (bool, error) {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	result, err := db.Exec("UPDATE totp_recovery_codes SET used=true WHERE code_hash=$1 AND user_id=$2 AND NOT used",
+		hashToken(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	req, _ := http.NewRequest("POST", "/api/users/me/totp", nil)
+	req.AddCookie(cookie)
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var enrollment totpEnrollment
+	json.Unmarshal(response.Body.Bytes(), &enrollment)
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatalf("Invalid secret %s", enrollment.Secret)
+	}
+	step := time.Now().Unix() / totpPeriod
+
+	// A wrong code doesn't enable two-factor authentication
+	req, _ = http.NewRequest("POST", "/api/users/me/totp/confirm", bytes.NewBuffer([]byte(`{"code":"abcdef"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	var jsonStr = []byte(fmt.Sprintf(`{"code":"%s"}`, totpCode(secret, step)))
+	req, _ = http.NewRequest("POST", "/api/users/me/totp/confirm", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	var codes recoveryCodes
+	json.Unmarshal(response.Body.Bytes(), &codes)
+	if len(codes.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes. Got %d", recoveryCodeCount, len(codes.RecoveryCodes))
+	}
+	if len(strings.Replace(codes.RecoveryCodes[0], "-", "", -1)) != 16 {
+		t.Errorf("Expected recovery codes of 80 bits. Got '%s'", codes.RecoveryCodes[0])
+	}
+
+	// The password alone returns only a challenge
+	challenge := loginChallengeFor(t, "user1@localhost.com", "password1")
+
+	// The code of the confirmation can't be used again
+	req, _ = http.NewRequest("POST", "/api/users/login/totp", bytes.NewBuffer([]byte(fmt.Sprintf(`{"challenge":"%s", "code":"%s"}`, challenge, totpCode(secret, step)))))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/users/login/totp", bytes.NewBuffer([]byte(fmt.Sprintf(`{"challenge":"%s", "code":"%s"}`, challenge, totpCode(secret, step+1)))))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if getCookie(response, "token") == nil {
+		t.Error("Expected a token cookie")
+	}
+
+	// The challenge can be used only once
+	req, _ = http.NewRequest("POST", "/api/users/login/totp", bytes.NewBuffer([]byte(fmt.Sprintf(`{"challenge":"%s", "recoveryCode":"%s"}`, challenge, codes.RecoveryCodes[0]))))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// Recovery codes can be used only once
+	challenge = loginChallengeFor(t, "user1@localhost.com", "password1")
+	req, _ = http.NewRequest("POST", "/api/users/login/totp", bytes.NewBuffer([]byte(fmt.Sprintf(`{"challenge":"%s", "recoveryCode":"%s"}`, challenge, codes.RecoveryCodes[0]))))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	challenge = loginChallengeFor(t, "user1@localhost.com", "password1")
+	req, _ = http.NewRequest("POST", "/api/users/login/totp", bytes.NewBuffer([]byte(fmt.Sprintf(`{"challenge":"%s", "recoveryCode":"%s"}`, challenge, codes.RecoveryCodes[0]))))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	// Disabling requires the password
+	req, _ = http.NewRequest("DELETE", "/api/users/me/totp", bytes.NewBuffer([]byte(`{"password":"wrongpassword"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/users/me/totp", bytes.NewBuffer([]byte(`{"password":"password1"}`)))
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	if authenticate("user1@localhost.com", "password1") == nil {
+		t.Error("Expected login with the password only")
+	}
+}
+
+// loginChallengeFor logs in with the password and returns the challenge of the second step
+func loginChallengeFor(t *testing.T, username, password string) string {
+	var jsonStr = []byte(fmt.Sprintf(`{"username":"%s", "password": "%s"}`, username, password))
+	req, _ := http.NewRequest("POST", "/api/users/login", bytes.NewBuffer(jsonStr))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+	if getCookie(response, "token") != nil {
+		t.Error("Expected no token cookie before the code")
+	}
+
+	var challenge loginChallengeResponse
+	json.Unmarshal(response.Body.Bytes(), &challenge)
+	if challenge.Result != "totpRequired" || challenge.Challenge == "" {
+		t.Fatalf("Expected a challenge. Got %s", response.Body.String())
+	}
+	return challenge.Challenge
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Weights are stored in kilograms and converted to the unit of the user on input and output,
+// so that sets entered in different units are aggregated and compared correctly
+const (
+	unitKilograms = "kg"
+	unitPounds    = "lb"
+)
+
+// kilogramsPerPound is the exact definition of the international pound
+const kilogramsPerPound = 0.45359237
+
+// preferences are the settings of a user
+type preferences struct {
+	WeightUnit string `json:"weightUnit" validate:"required,oneof=kg lb"`
+}
+
+func (s *Server) handleGetPreferences() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var preferences preferences
+		if err := preferences.getPreferences(s.DB, claims.UserID); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusNotFound, "User not found")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, preferences)
+	}
+}
+
+func (s *Server) handleUpdatePreferences() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var preferences preferences
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&preferences); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate preferences
+		err = s.Validator.Struct(preferences)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		affectedRows, err := preferences.updatePreferences(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, preferences)
+	}
+}
+
+func (p *preferences) getPreferences(db queryer, userID string) error {
+	return db.QueryRow("SELECT weight_unit FROM users WHERE user_id=$1", userID).Scan(&p.WeightUnit)
+}
+
+func (p *preferences) updatePreferences(db queryer, userID string) (int64, error) {
+	result, err := db.Exec("UPDATE users SET weight_unit=$2, modified=$3 WHERE user_id=$1", userID, p.WeightUnit, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, err
+}
+
+// getWeightUnit returns the unit the user wants to see weights in
+func getWeightUnit(db queryer, userID string) (string, error) {
+	var preferences preferences
+	if err := preferences.getPreferences(db, userID); err != nil {
+		return "", err
+	}
+	return preferences.WeightUnit, nil
+}
+
+// toKilograms converts a weight in the given unit to kilograms
+func toKilograms(weight float64, unit string) float64 {
+	if unit == unitPounds {
+		return weight * kilogramsPerPound
+	}
+	return weight
+}
+
+// toStoredKilograms converts a weight in the given unit to kilograms rounded to the four decimals of the
+// weight column, so that a filter matches the sets stored with the same weight
+func toStoredKilograms(weight float64, unit string) float64 {
+	return math.Round(toKilograms(weight, unit)*10000) / 10000
+}
+
+// fromKilograms converts a weight in kilograms to the given unit. The result is rounded
+// to two decimals, so that weights entered in pounds come back as they were entered.
+func fromKilograms(weight float64, unit string) float64 {
+	if unit == unitPounds {
+		weight = weight / kilogramsPerPound
+	}
+	return math.Round(weight*100) / 100
+}
+
+// toKilograms converts the weight of the set to kilograms for storing. The weight is in the
+// unit of the set or, if the set has no unit, in the given unit.
+func (s *set) toKilograms(unit string) {
+	if s.Unit == "" {
+		s.Unit = unit
+	}
+	s.Weight = toKilograms(s.Weight, s.Unit)
+	s.Unit = unitKilograms
+}
+
+// fromKilograms converts the stored weight of the set to the given unit
+func (s *set) fromKilograms(unit string) {
+	s.Weight = fromKilograms(s.Weight, unit)
+	s.Unit = unit
+}
+
+// fromKilograms converts the weights of the record to the given unit
+func (r *record) fromKilograms(unit string) {
+	if r.Type != "mostRepetitions" {
+		r.Value = fromKilograms(r.Value, unit)
+	}
+	r.Weight = fromKilograms(r.Weight, unit)
+}
+
+// fromKilograms converts the weights of the stat to the given unit
+func (s *stat) fromKilograms(unit string) {
+	s.Volume = fromKilograms(s.Volume, unit)
+	s.MaxWeight = fromKilograms(s.MaxWeight, unit)
+	if s.Estimated1RM != nil {
+		estimated1RM := fromKilograms(*s.Estimated1RM, unit)
+		s.Estimated1RM = &estimated1RM
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestWeightUnits(t *testing.T) {
+	clearTables()
+	createTestUsers()
+	cookie := authenticate("user1@localhost.com", "password1")
+
+	// Weights are in kilograms by default, but the unit of a set can be given explicitly
+	createSet(t, cookie, fmt.Sprintf(`{"weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	m := createSet(t, cookie, fmt.Sprintf(`{"weight": 225, "unit": "lb", "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	if m.Weight != 102.06 || m.Unit != "kg" {
+		t.Errorf("Expected weight to be '102.06 kg'. Got '%v %s'", m.Weight, m.Unit)
+	}
+
+	// Records compare the weights in the same unit
+	if types := recordTypeNames(m.NewRecords); types != "heaviestWeight,estimated1RM,bestVolume," {
+		t.Errorf("Expected new records 'heaviestWeight,estimated1RM,bestVolume,'. Got '%s'", types)
+	}
+
+	// Weights are returned in the unit of the user
+	var jsonStr = []byte(`{"weightUnit": "lb"}`)
+	req, _ := http.NewRequest("PUT", "/api/users/me/preferences", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/v1/sets?sort=weight", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+
+	var n sets
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Results != 2 || n.Sets[0].Weight != 220.46 || n.Sets[1].Weight != 225 || n.Sets[1].Unit != "lb" {
+		t.Errorf("Expected weights '220.46 lb' and '225 lb'. Got '%+v'", n.Sets)
+	}
+
+	// Filters in pounds match the sets entered with the same weight in pounds
+	req, _ = http.NewRequest("GET", "/api/v1/sets?minWeight=225&maxWeight=225", nil)
+	req.AddCookie(cookie)
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	n = sets{}
+	json.Unmarshal(response.Body.Bytes(), &n)
+	if n.Results != 1 || n.Sets[0].Weight != 225 {
+		t.Errorf("Expected the set of '225 lb'. Got '%+v'", n.Sets)
+	}
+
+	// Invalid unit
+	jsonStr = []byte(`{"weightUnit": "stone"}`)
+	req, _ = http.NewRequest("PUT", "/api/users/me/preferences", bytes.NewBuffer(jsonStr))
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", "application/json")
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
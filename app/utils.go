@@ -1,12 +1,27 @@
 package app
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
+// queryer is implemented by both *sql.DB and *sql.Tx, so the same queries can be run inside a transaction
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows, so the same function can read a row of either
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
@@ -25,3 +40,74 @@ func CheckEnvVariableExists(name string) {
 		log.Panicf("%s not set", name)
 	}
 }
+
+// getEnv returns the value of an environment variable or the fallback if the variable is not set
+func getEnv(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt returns the value of an integer environment variable or the fallback if the variable is not set
+func getEnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvDuration returns the value of a duration environment variable, e.g. "15m", or the fallback if the variable is not set
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// parseTimeParam parses an optional RFC 3339 timestamp from the request parameters. Returns nil if the parameter is not present.
+func parseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &t, nil
+}
+
+// parseIntParam parses an optional integer from the request parameters. Returns nil if the parameter is not present.
+func parseIntParam(r *http.Request, name string) (*int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &i, nil
+}
+
+// parseFloatParam parses an optional decimal number from the request parameters. Returns nil if the parameter is not present.
+func parseFloatParam(r *http.Request, name string) (*float64, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &f, nil
+}
@@ -3,7 +3,10 @@ package app
 import (
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"sync"
 	"testing"
+	"time"
 )
 
 func executeRequest(req *http.Request) *httptest.ResponseRecorder {
@@ -13,8 +16,74 @@ func executeRequest(req *http.Request) *httptest.ResponseRecorder {
 	return rr
 }
 
+// executeHandler executes the request with a handler that isn't routed, such as a middleware
+func executeHandler(h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	return rr
+}
+
 func checkResponseCode(t *testing.T, expected, actual int) {
 	if expected != actual {
 		t.Errorf("Expected response code %d. Got %d\n", expected, actual)
 	}
 }
+
+// testMailer records the emails sent during the tests instead of sending them
+var testMailer recordingMailer
+
+type sentEmail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type recordingMailer struct {
+	mutex  sync.Mutex
+	emails []sentEmail
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.emails = append(m.emails, sentEmail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// clear forgets the emails sent so far
+func (m *recordingMailer) clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.emails = nil
+}
+
+// lastEmail returns the last email sent to the address or nil if no email was sent
+func (m *recordingMailer) lastEmail(to string) *sentEmail {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := len(m.emails) - 1; i >= 0; i-- {
+		if m.emails[i].To == to {
+			return &m.emails[i]
+		}
+	}
+	return nil
+}
+
+// emailToken returns the token of the link in the last email sent to the address. Emails sent in the
+// background are waited for a while.
+func emailToken(t *testing.T, to string) string {
+	email := testMailer.lastEmail(to)
+	for i := 0; email == nil && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		email = testMailer.lastEmail(to)
+	}
+	if email == nil {
+		t.Fatalf("Expected an email to '%s'", to)
+	}
+	matches := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(email.Body)
+	if matches == nil {
+		t.Fatalf("Expected a token in the email. Got '%s'", email.Body)
+	}
+	return matches[1]
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type verifyEmail struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type resendVerification struct {
+	Username string `json:"username" validate:"required,email"`
+}
+
+// emailVerificationToken is a single-use token emailed to a new user for verifying that they own the
+// email address. Only the hash of the token is stored.
+type emailVerificationToken struct {
+	Hash    string
+	UserID  string
+	Expires time.Time
+	Used    bool
+	Created time.Time
+}
+
+func (s *Server) handleVerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request verifyEmail
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err := s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tx, err := s.DB.Begin()
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		defer tx.Rollback()
+
+		var token emailVerificationToken
+		if err := token.getEmailVerificationToken(tx, request.Token); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+		if token.Used || time.Now().After(token.Expires) {
+			respondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+			return
+		}
+
+		if err := verifyUser(tx, token.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (s *Server) handleResendVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request resendVerification
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate user input
+		err := s.Validator.Struct(request)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Requests are counted like failed logins, so that the emails can't be used for flooding a mailbox
+		if !s.limitEmailRequests(w, r, "verify", request.Username) {
+			return
+		}
+
+		// The response is the same whether the user exists or is verified already, so that it doesn't reveal the
+		// users. The email is sent in the background, so that the time of the response doesn't reveal them either.
+		user := user{Username: request.Username}
+		if err := user.getUserByUsername(s.DB); err != nil && err != sql.ErrNoRows {
+			log.Println(err.Error())
+		} else if err == nil && !user.Verified {
+			go func() {
+				if err := s.sendVerificationEmail(s.DB, &user); err != nil {
+					log.Println(err.Error())
+				}
+			}()
+		}
+
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+// sendVerificationEmail creates a new verification token for the user and emails the link for verifying
+func (s *Server) sendVerificationEmail(db queryer, user *user) error {
+	tokenValue, err := newRandomToken()
+	if err != nil {
+		return err
+	}
+
+	current := time.Now()
+	token := emailVerificationToken{Hash: hashToken(tokenValue), UserID: user.UserID, Expires: current.Add(s.EmailVerificationTTL), Created: current}
+	if err := token.createEmailVerificationToken(db); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Verify your email address at %s/verify-email?token=%s\n\nThe link expires in %s. If you didn't register, you can ignore this email.",
+		s.AppURL, tokenValue, s.EmailVerificationTTL)
+	return s.Mailer.Send(user.Username, "Verify your email address", body)
+}
+
+func (t *emailVerificationToken) createEmailVerificationToken(db queryer) error {
+	_, err := db.Exec(
+		"INSERT INTO email_verification_tokens(token_hash, user_id, expires, used, created) VALUES($1, $2, $3, false, $4)",
+		t.Hash, t.UserID, t.Expires, t.Created)
+
+	return err
+}
+
+// getEmailVerificationToken finds a verification token by its value and locks it until the end of the transaction
+func (t *emailVerificationToken) getEmailVerificationToken(db queryer, token string) error {
+	return db.QueryRow("SELECT token_hash, user_id, expires, used, created FROM email_verification_tokens WHERE token_hash=$1 FOR UPDATE",
+		hashToken(token)).Scan(&t.Hash, &t.UserID, &t.Expires, &t.Used, &t.Created)
+}
+
+// verifyUser marks the user verified and all the verification tokens of the user used
+func verifyUser(db queryer, userID string) error {
+	if _, err := db.Exec("UPDATE users SET verified=true, modified=$2 WHERE user_id=$1", userID, time.Now()); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE email_verification_tokens SET used=true WHERE user_id=$1", userID)
+	return err
+}
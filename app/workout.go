@@ -0,0 +1,365 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type workout struct {
+	ID       int        `json:"id"`
+	UserID   string     `json:"userId"`
+	Started  time.Time  `json:"started" validate:"required"`
+	Ended    *time.Time `json:"ended" validate:"omitempty,gtfield=Started"`
+	Notes    string     `json:"notes"`
+	Location string     `json:"location"`
+	Created  time.Time  `json:"created"`
+	Modified time.Time  `json:"modified"`
+	// ImportKey identifies an imported workout in the file it was imported from
+	ImportKey string `json:"-"`
+}
+
+// workoutWithSets is a workout including the sets done during it
+type workoutWithSets struct {
+	workout
+	Sets []set `json:"sets"`
+}
+
+type workouts struct {
+	Results  int       `json:"results"`
+	Skip     int       `json:"skip"`
+	Limit    int       `json:"limit"`
+	Workouts []workout `json:"workouts"`
+}
+
+func (s *Server) handleGetWorkout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid workout ID")
+			return
+		}
+
+		workout := workoutWithSets{workout: workout{ID: id}}
+		if err := workout.getWorkout(s.DB, claims.UserID); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusNotFound, "Workout not found")
+			default:
+				log.Println(err.Error())
+				respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			}
+			return
+		}
+
+		workout.Sets, err = workout.getWorkoutSets(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		unit, err := getWeightUnit(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		for i := range workout.Sets {
+			workout.Sets[i].fromKilograms(unit)
+		}
+
+		respondWithJSON(w, http.StatusOK, workout)
+	}
+}
+
+func (s *Server) handleGetWorkouts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var workout workout
+		skip, _ := strconv.Atoi(r.FormValue("skip"))
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+
+		if limit < 1 {
+			limit = 10
+		}
+		if limit > s.MaxPageSize {
+			limit = s.MaxPageSize
+		}
+		if skip < 0 {
+			skip = 0
+		}
+
+		workouts := workouts{Skip: skip, Limit: limit}
+		result, err := workout.getWorkouts(s.DB, skip, limit, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		workouts.Workouts = result
+		workouts.Results = len(result)
+		respondWithJSON(w, http.StatusOK, workouts)
+	}
+}
+
+func (s *Server) handleCreateWorkout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		var workout workout
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&workout); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate workout
+		err = s.Validator.Struct(workout)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := workout.createWorkout(s.DB, claims.UserID); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, workout)
+	}
+}
+
+func (s *Server) handleUpdateWorkout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid workout ID")
+			return
+		}
+
+		var workout workout
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&workout); err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+			return
+		}
+		defer r.Body.Close()
+
+		// Validate workout
+		err = s.Validator.Struct(workout)
+		if err != nil {
+			log.Printf(err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		workout.ID = id
+		affectedRows, err := workout.updateWorkout(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, workout)
+	}
+}
+
+func (s *Server) handleDeleteWorkout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get user information
+		claims, err := parseToken(w, r)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		// Logic
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusBadRequest, "Invalid workout ID")
+			return
+		}
+
+		workout := workout{ID: id}
+		affectedRows, err := workout.deleteWorkout(s.DB, claims.UserID)
+		if err != nil {
+			log.Println(err.Error())
+			respondWithError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+
+		if affectedRows == 0 {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, map[string]string{"result": "success"})
+	}
+}
+
+func (wo *workout) getWorkout(db *sql.DB, userID string) error {
+	return db.QueryRow("SELECT user_id, started, ended, notes, location, created, modified FROM workouts WHERE id=$1 AND user_id=$2",
+		wo.ID, userID).Scan(&wo.UserID, &wo.Started, &wo.Ended, &wo.Notes, &wo.Location, &wo.Created, &wo.Modified)
+}
+
+func (wo *workout) getWorkouts(db *sql.DB, start, count int, userID string) ([]workout, error) {
+	rows, err := db.Query(
+		"SELECT id, user_id, started, ended, notes, location, created, modified FROM workouts WHERE user_id=$1 ORDER BY started DESC LIMIT $2 OFFSET $3",
+		userID, count, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workouts := []workout{}
+	for rows.Next() {
+		var wo workout
+		if err := rows.Scan(&wo.ID, &wo.UserID, &wo.Started, &wo.Ended, &wo.Notes, &wo.Location, &wo.Created, &wo.Modified); err != nil {
+			return nil, err
+		}
+		workouts = append(workouts, wo)
+	}
+
+	return workouts, nil
+}
+
+// getWorkoutSets returns the sets of the workout in the order they were done
+func (wo *workout) getWorkoutSets(db *sql.DB, userID string) ([]set, error) {
+	rows, err := db.Query(
+		"SELECT "+setColumns+" FROM sets s JOIN exercises e ON e.id=s.exercise_id WHERE s.workout_id=$1 AND s.user_id=$2 ORDER BY s.created ASC, s.id ASC",
+		wo.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := []set{}
+	for rows.Next() {
+		var s set
+		if err := s.scan(rows); err != nil {
+			return nil, err
+		}
+		sets = append(sets, s)
+	}
+
+	return sets, nil
+}
+
+func (wo *workout) updateWorkout(db *sql.DB, userID string) (int64, error) {
+	result, err :=
+		db.Exec("UPDATE workouts SET started=$3, ended=$4, notes=$5, location=$6, modified=$7 WHERE id=$1 AND user_id=$2",
+			wo.ID, userID, wo.Started, wo.Ended, wo.Notes, wo.Location, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, err
+}
+
+func (wo *workout) deleteWorkout(db *sql.DB, userID string) (int64, error) {
+	result, err := db.Exec("DELETE FROM workouts WHERE id=$1 and user_id=$2", wo.ID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, err
+}
+
+func (wo *workout) createWorkout(db queryer, userID string) error {
+	current := time.Now()
+	err := db.QueryRow(
+		"INSERT INTO workouts(user_id, started, ended, notes, location, import_key, created, modified) VALUES($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8) RETURNING id, created, modified",
+		userID, wo.Started, wo.Ended, wo.Notes, wo.Location, wo.ImportKey, current, current).Scan(&wo.ID, &wo.Created, &wo.Modified)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getImportedWorkout finds a workout of the user by its import key, or the workout exported from the same account
+func (wo *workout) getImportedWorkout(db queryer, userID string) error {
+	id, started := parseExportKey("workout", wo.ImportKey)
+	return db.QueryRow(
+		"SELECT id, user_id, started, ended, notes, location, created, modified FROM workouts WHERE (import_key=$1 OR (id=$3 AND started=$4)) AND user_id=$2",
+		wo.ImportKey, userID, id, started).Scan(&wo.ID, &wo.UserID, &wo.Started, &wo.Ended, &wo.Notes, &wo.Location, &wo.Created, &wo.Modified)
+}
+
+func (wo *workout) checkIfWorkoutExists(db queryer, userID string) (bool, error) {
+	count := 0
+	err := db.QueryRow("SELECT COUNT(id) FROM workouts WHERE id=$1 AND user_id=$2", wo.ID, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCreateWorkout(t *testing.T) {
+	clearTables()
+	createTestUsers()
+
+	// Valid request
+	var jsonStr1 = []byte(`{"started": "2021-01-01T10:00:00Z", "ended": "2021-01-01T11:00:00Z", "notes": "legs", "location": "home gym"}`)
+	req, _ := http.NewRequest("POST", "/api/v1/workouts", bytes.NewBuffer(jsonStr1))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, response.Code)
+
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if m["notes"] != "legs" {
+		t.Errorf("Expected notes to be 'legs'. Got '%v'", m["notes"])
+	}
+
+	if m["location"] != "home gym" {
+		t.Errorf("Expected location to be 'home gym'. Got '%v'", m["location"])
+	}
+
+	// Numbers are compared to floats because JSON unmarshaling converts numbers to
+	// floats, when the target is a map[string]interface{}
+	if m["id"] != 1.0 {
+		t.Errorf("Expected workout ID to be '1'. Got '%v'", m["id"])
+	}
+
+	// Missing start time
+	var jsonStr2 = []byte(`{"notes": "legs"}`)
+	req, _ = http.NewRequest("POST", "/api/v1/workouts", bytes.NewBuffer(jsonStr2))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+
+	// End time before start time
+	var jsonStr3 = []byte(`{"started": "2021-01-01T10:00:00Z", "ended": "2021-01-01T09:00:00Z"}`)
+	req, _ = http.NewRequest("POST", "/api/v1/workouts", bytes.NewBuffer(jsonStr3))
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	req.Header.Set("Content-Type", "application/json")
+
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestGetWorkoutWithSets(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addWorkouts(userIDs)
+
+	// Attach two sets to the workout
+	for _, exercise := range []string{"Squat", "Deadlift"} {
+		var jsonStr = []byte(fmt.Sprintf(`{"workoutId": 1, "weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID(exercise)))
+		req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+		req.AddCookie(authenticate("user1@localhost.com", "password1"))
+		req.Header.Set("Content-Type", "application/json")
+		response := executeRequest(req)
+		checkResponseCode(t, http.StatusCreated, response.Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/api/v1/workouts/1", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	var m workoutWithSets
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	if len(m.Sets) != 2 {
+		t.Fatalf("Expected the workout to have '2' sets. Got '%v'", len(m.Sets))
+	}
+
+	if m.Sets[0].Exercise != "Squat" || m.Sets[1].Exercise != "Deadlift" {
+		t.Errorf("Expected the sets to be in the order they were created. Got '%v' and '%v'", m.Sets[0].Exercise, m.Sets[1].Exercise)
+	}
+
+	// Other user can't see the workout
+	req, _ = http.NewRequest("GET", "/api/v1/workouts/1", nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func TestAttachSetToOtherUsersWorkout(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addWorkouts(userIDs)
+
+	// Workout 1 belongs to user1
+	var jsonStr = []byte(fmt.Sprintf(`{"workoutId": 1, "weight": 100, "exerciseId":%d, "repetitions":5}`, getExerciseID("Squat")))
+	req, _ := http.NewRequest("POST", "/api/v1/sets", bytes.NewBuffer(jsonStr))
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, response.Code)
+}
+
+func TestDeleteWorkout(t *testing.T) {
+	clearTables()
+	userIDs := createTestUsers()
+	addWorkouts(userIDs)
+
+	// Try to delete workout with other user
+	req, _ := http.NewRequest("DELETE", "/api/v1/workouts/1", nil)
+	req.AddCookie(authenticate("user2@localhost.com", "password2"))
+	response := executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+
+	// Delete workout with correct user
+	req, _ = http.NewRequest("DELETE", "/api/v1/workouts/1", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, response.Code)
+
+	// Check that workout has been deleted
+	req, _ = http.NewRequest("GET", "/api/v1/workouts/1", nil)
+	req.AddCookie(authenticate("user1@localhost.com", "password1"))
+	response = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, response.Code)
+}
+
+func addWorkouts(userIDs []string) {
+	if len(userIDs) == 0 {
+		log.Fatal("No userIDs available")
+		return
+	}
+
+	current := time.Now()
+	for _, userID := range userIDs {
+		_, err := testServer.DB.Exec("INSERT INTO workouts(user_id, started, notes, location, created, modified) VALUES($1, $2, $3, $4, $5, $6)", userID, current, "", "", current, current)
+		if err != nil {
+			log.Fatal(err.Error())
+			break
+		}
+	}
+}
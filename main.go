@@ -7,7 +7,6 @@ import (
 )
 
 func main() {
-	app.CheckEnvVariableExists("JWT_KEY")
 	a := app.Server{}
 	a.Initialize(
 		os.Getenv("DB_USERNAME"),
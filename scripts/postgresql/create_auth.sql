@@ -4,6 +4,13 @@ CREATE TABLE users (
     username TEXT NOT NULL UNIQUE,
     password TEXT NOT NULL,
     enabled INTEGER NOT NULL DEFAULT 1,
+    weight_unit TEXT NOT NULL DEFAULT 'kg',
+    tokens_valid_after TIMESTAMP WITH TIME ZONE,
+    verified BOOLEAN NOT NULL DEFAULT false,
+    totp_secret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    deletion_scheduled TIMESTAMP WITH TIME ZONE,
     created TIMESTAMP WITH TIME ZONE NOT NULL,
     modified TIMESTAMP WITH TIME ZONE NOT NULL,
     CONSTRAINT users_pkey PRIMARY KEY (user_id)
@@ -24,4 +31,160 @@ CREATE UNIQUE INDEX ix_users_user_id
 
 -- create index for authorities
 CREATE UNIQUE INDEX ix_auth_user_id
-    on authorities (user_id,authority);
\ No newline at end of file
+    on authorities (user_id,authority);
+
+-- create refresh tokens table, tokens are stored as hashes
+CREATE TABLE refresh_tokens (
+    token_hash TEXT NOT NULL,
+    family_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create index for refresh token families
+CREATE INDEX ix_refresh_tokens_family_id
+    on refresh_tokens (family_id);
+
+-- create revoked tokens table, access tokens are kept here until they expire
+CREATE TABLE revoked_tokens (
+    jti TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+);
+
+-- create password reset tokens table, tokens are stored as hashes
+CREATE TABLE password_reset_tokens (
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT password_reset_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create email verification tokens table, tokens are stored as hashes
+CREATE TABLE email_verification_tokens (
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT email_verification_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create login challenges table for the second step of logging in, challenges are stored as hashes
+CREATE TABLE login_challenges (
+    challenge_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT login_challenges_pkey PRIMARY KEY (challenge_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create recovery codes table for two-factor authentication, codes are stored as hashes
+CREATE TABLE totp_recovery_codes (
+    code_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT totp_recovery_codes_pkey PRIMARY KEY (code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create audit log of the actions of administrators. The log is kept when users are deleted.
+CREATE TABLE admin_audit_log (
+    id SERIAL,
+    admin_user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_user_id TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT admin_audit_log_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX ix_admin_audit_log_target_user_id
+    on admin_audit_log (target_user_id, created);
+
+-- create login failures table, failed logins are counted per username and per IP address
+CREATE TABLE login_failures (
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_failure TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT login_failures_pkey PRIMARY KEY (key)
+);
+
+-- create OpenID Connect states table, the state of each login at an external identity provider
+CREATE TABLE oidc_states (
+    state_hash TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    user_id TEXT,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT oidc_states_pkey PRIMARY KEY (state_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create identities table, identities of external providers are linked to users
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT user_identities_pkey PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX ix_user_identities_user_id
+    on user_identities (user_id);
+
+-- create API keys table, only the hashes of the keys are stored
+CREATE TABLE api_keys (
+    id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    last_used TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX ix_api_keys_user_id
+    on api_keys (user_id);
+
+-- create sessions table, a session is a login on a device and the family of its refresh tokens
+CREATE TABLE sessions (
+    id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    CONSTRAINT sessions_pkey PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX ix_sessions_user_id
+    on sessions (user_id);
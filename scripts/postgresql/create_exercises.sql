@@ -0,0 +1,79 @@
+CREATE TABLE IF NOT EXISTS exercises
+(
+    id SERIAL,
+    user_id TEXT,
+	name TEXT NOT NULL,
+	muscle_group TEXT NOT NULL DEFAULT '',
+	equipment TEXT NOT NULL DEFAULT '',
+	category TEXT NOT NULL DEFAULT '',
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+	modified TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT exercises_pkey PRIMARY KEY (id)
+);
+
+-- built-in exercises have no user_id, names are unique per user case-insensitively
+CREATE UNIQUE INDEX IF NOT EXISTS ix_exercises_user_id_name
+    on exercises (COALESCE(user_id, ''), LOWER(name));
+
+-- alternative names of built-in exercises, used when mapping free-text exercise names
+CREATE TABLE IF NOT EXISTS exercise_aliases
+(
+    alias TEXT NOT NULL,
+	exercise_id INTEGER NOT NULL REFERENCES exercises(id) ON DELETE CASCADE,
+	CONSTRAINT exercise_aliases_pkey PRIMARY KEY (alias)
+);
+
+-- built-in exercises
+INSERT INTO exercises(name, muscle_group, equipment, category, created, modified) VALUES
+    ('Squat', 'quadriceps', 'barbell', 'strength', NOW(), NOW()),
+    ('Front squat', 'quadriceps', 'barbell', 'strength', NOW(), NOW()),
+    ('Leg press', 'quadriceps', 'machine', 'strength', NOW(), NOW()),
+    ('Lunge', 'quadriceps', 'dumbbell', 'strength', NOW(), NOW()),
+    ('Deadlift', 'back', 'barbell', 'strength', NOW(), NOW()),
+    ('Romanian deadlift', 'hamstrings', 'barbell', 'strength', NOW(), NOW()),
+    ('Leg curl', 'hamstrings', 'machine', 'strength', NOW(), NOW()),
+    ('Hip thrust', 'glutes', 'barbell', 'strength', NOW(), NOW()),
+    ('Calf raise', 'calves', 'machine', 'strength', NOW(), NOW()),
+    ('Bench press', 'chest', 'barbell', 'strength', NOW(), NOW()),
+    ('Incline bench press', 'chest', 'barbell', 'strength', NOW(), NOW()),
+    ('Dumbbell bench press', 'chest', 'dumbbell', 'strength', NOW(), NOW()),
+    ('Dip', 'chest', 'bodyweight', 'strength', NOW(), NOW()),
+    ('Push-up', 'chest', 'bodyweight', 'strength', NOW(), NOW()),
+    ('Overhead press', 'shoulders', 'barbell', 'strength', NOW(), NOW()),
+    ('Lateral raise', 'shoulders', 'dumbbell', 'strength', NOW(), NOW()),
+    ('Pull-up', 'back', 'bodyweight', 'strength', NOW(), NOW()),
+    ('Chin-up', 'back', 'bodyweight', 'strength', NOW(), NOW()),
+    ('Barbell row', 'back', 'barbell', 'strength', NOW(), NOW()),
+    ('Lat pulldown', 'back', 'cable', 'strength', NOW(), NOW()),
+    ('Seated cable row', 'back', 'cable', 'strength', NOW(), NOW()),
+    ('Biceps curl', 'biceps', 'dumbbell', 'strength', NOW(), NOW()),
+    ('Triceps pushdown', 'triceps', 'cable', 'strength', NOW(), NOW()),
+    ('Plank', 'core', 'bodyweight', 'strength', NOW(), NOW()),
+    ('Running', 'full_body', 'other', 'cardio', NOW(), NOW()),
+    ('Rowing machine', 'full_body', 'machine', 'cardio', NOW(), NOW())
+ON CONFLICT DO NOTHING;
+
+-- aliases of built-in exercises
+INSERT INTO exercise_aliases(alias, exercise_id)
+    SELECT a.alias, e.id FROM (VALUES
+        ('back squat', 'Squat'),
+        ('squats', 'Squat'),
+        ('bench', 'Bench press'),
+        ('bp', 'Bench press'),
+        ('flat bench', 'Bench press'),
+        ('dl', 'Deadlift'),
+        ('rdl', 'Romanian deadlift'),
+        ('ohp', 'Overhead press'),
+        ('military press', 'Overhead press'),
+        ('pullup', 'Pull-up'),
+        ('pull up', 'Pull-up'),
+        ('chinup', 'Chin-up'),
+        ('chin up', 'Chin-up'),
+        ('pushup', 'Push-up'),
+        ('push up', 'Push-up'),
+        ('row', 'Barbell row'),
+        ('bent over row', 'Barbell row'),
+        ('curl', 'Biceps curl')
+    ) AS a(alias, name)
+    JOIN exercises e ON e.user_id IS NULL AND e.name = a.name
+ON CONFLICT DO NOTHING;
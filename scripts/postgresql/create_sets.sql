@@ -1,11 +1,26 @@
+-- weights are stored in kilograms regardless of the unit they were entered in
 CREATE TABLE IF NOT EXISTS sets
 (
     id SERIAL,
-    user_id TEXT NOT NULL,
-	weight NUMERIC(10,2) NOT NULL DEFAULT 0.00,
-	exercise TEXT NOT NULL,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	workout_id INTEGER REFERENCES workouts(id) ON DELETE SET NULL,
+	weight NUMERIC(10,4) NOT NULL DEFAULT 0.00,
+	exercise_id INTEGER NOT NULL REFERENCES exercises(id),
 	repetitions INTEGER,
+	rpe NUMERIC(3,1),
+	rir INTEGER,
+	tempo TEXT NOT NULL DEFAULT '',
+	rest_seconds INTEGER,
+	duration_seconds INTEGER,
+	distance NUMERIC(10,2),
+	set_type TEXT NOT NULL DEFAULT 'working',
+	notes TEXT NOT NULL DEFAULT '',
+	import_key TEXT,
 	created TIMESTAMP WITH TIME ZONE NOT NULL,
 	modified TIMESTAMP WITH TIME ZONE NOT NULL,
 	CONSTRAINT sets_pkey PRIMARY KEY (id)
-);
\ No newline at end of file
+);
+
+-- imported sets are identified by their import keys to detect duplicates
+CREATE UNIQUE INDEX IF NOT EXISTS ix_sets_user_id_import_key
+    on sets (user_id, import_key) WHERE import_key IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS workouts
+(
+    id SERIAL,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	started TIMESTAMP WITH TIME ZONE NOT NULL,
+	ended TIMESTAMP WITH TIME ZONE,
+	notes TEXT NOT NULL DEFAULT '',
+	location TEXT NOT NULL DEFAULT '',
+	import_key TEXT,
+	created TIMESTAMP WITH TIME ZONE NOT NULL,
+	modified TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT workouts_pkey PRIMARY KEY (id)
+);
+
+-- imported workouts are identified by their import keys to detect duplicates
+CREATE UNIQUE INDEX IF NOT EXISTS ix_workouts_user_id_import_key
+    on workouts (user_id, import_key) WHERE import_key IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,6 @@
+-- Adds the scheduled deletion of accounts to databases created before deleting accounts existed. Run once.
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled TIMESTAMP WITH TIME ZONE;
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Adds the audit log of administrators to databases created before the admin API existed. Run once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL,
+    admin_user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_user_id TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT admin_audit_log_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS ix_admin_audit_log_target_user_id
+    on admin_audit_log (target_user_id, created);
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Adds email verification to databases created before it existed. Existing users are
+-- considered verified, so that they can still log in. Run once.
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT false;
+UPDATE users SET verified = true;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT email_verification_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+COMMIT;
@@ -0,0 +1,32 @@
+-- Migrates sets created before the exercise catalog existed from the free-text
+-- exercise column to exercise IDs. Run once after create_exercises.sql.
+--
+-- Exercise strings are matched case-insensitively against built-in exercise names
+-- first and aliases second. Strings without a match become custom exercises of
+-- the user who logged them.
+BEGIN;
+
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS exercise_id INTEGER REFERENCES exercises(id);
+
+UPDATE sets s SET exercise_id = e.id
+    FROM exercises e
+    WHERE s.exercise_id IS NULL AND e.user_id IS NULL AND LOWER(e.name) = LOWER(TRIM(s.exercise));
+
+UPDATE sets s SET exercise_id = a.exercise_id
+    FROM exercise_aliases a
+    WHERE s.exercise_id IS NULL AND a.alias = LOWER(TRIM(s.exercise));
+
+INSERT INTO exercises(user_id, name, created, modified)
+    SELECT DISTINCT ON (user_id, LOWER(TRIM(exercise))) user_id, TRIM(exercise), NOW(), NOW()
+    FROM sets
+    WHERE exercise_id IS NULL
+ON CONFLICT DO NOTHING;
+
+UPDATE sets s SET exercise_id = e.id
+    FROM exercises e
+    WHERE s.exercise_id IS NULL AND e.user_id = s.user_id AND LOWER(e.name) = LOWER(TRIM(s.exercise));
+
+ALTER TABLE sets ALTER COLUMN exercise_id SET NOT NULL;
+ALTER TABLE sets DROP COLUMN exercise;
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Adds the import keys of sets and workouts, which detect duplicates when importing. Run once after
+-- "migrate_workouts.sql". Existing sets and workouts have no import key.
+BEGIN;
+
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS import_key TEXT;
+ALTER TABLE workouts ADD COLUMN IF NOT EXISTS import_key TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_sets_user_id_import_key
+    on sets (user_id, import_key) WHERE import_key IS NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_workouts_user_id_import_key
+    on workouts (user_id, import_key) WHERE import_key IS NOT NULL;
+
+COMMIT;
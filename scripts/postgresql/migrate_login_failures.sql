@@ -0,0 +1,12 @@
+-- Adds the table of failed logins to databases created before the lockout existed. Run once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS login_failures (
+    key TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_failure TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT login_failures_pkey PRIMARY KEY (key)
+);
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- Adds the tables of logins with external identity providers to databases created before them. Run once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS oidc_states (
+    state_hash TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    user_id TEXT,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT oidc_states_pkey PRIMARY KEY (state_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- create identities table, identities of external providers are linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT user_identities_pkey PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS ix_user_identities_user_id
+    on user_identities (user_id);
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Adds the table of password reset tokens to databases created before resetting passwords existed. Run once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    token_hash TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT password_reset_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+COMMIT;
@@ -0,0 +1,19 @@
+-- Adds the table of refresh tokens to databases created before refresh tokens existed. Run once.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash TEXT NOT NULL,
+    family_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT false,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS ix_refresh_tokens_family_id
+    on refresh_tokens (family_id);
+
+COMMIT;
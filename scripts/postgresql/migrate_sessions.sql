@@ -0,0 +1,21 @@
+-- Adds the table of sessions to databases created before them. Run once. The sessions of existing
+-- logins are recorded when their refresh tokens are used.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    CONSTRAINT sessions_pkey PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS ix_sessions_user_id
+    on sessions (user_id);
+
+COMMIT;
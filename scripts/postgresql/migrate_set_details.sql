@@ -0,0 +1,17 @@
+-- Adds the optional details of sets. Existing sets become working sets
+-- without details. Run once.
+BEGIN;
+
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS rpe NUMERIC(3,1);
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS rir INTEGER;
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS tempo TEXT NOT NULL DEFAULT '';
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS rest_seconds INTEGER;
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS duration_seconds INTEGER;
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS distance NUMERIC(10,2);
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS set_type TEXT NOT NULL DEFAULT 'working';
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+
+-- repetitions are no longer required, but missing repetitions are stored as zero
+UPDATE sets SET repetitions = 0 WHERE repetitions IS NULL;
+
+COMMIT;
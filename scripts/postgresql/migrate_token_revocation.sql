@@ -0,0 +1,14 @@
+-- Adds the revocation of access tokens to databases created before logging out existed. Run once.
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    expires TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+);
+
+COMMIT;
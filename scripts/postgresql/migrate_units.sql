@@ -0,0 +1,14 @@
+-- Adds weight units. Weights of existing sets are assumed to be in kilograms,
+-- which is the unit weights are stored in from now on. Run once.
+--
+-- Users who logged their sets in pounds can convert their existing sets with
+--     UPDATE sets SET weight = weight * 0.45359237 WHERE user_id = '<user_id>';
+-- after setting their weight unit to 'lb'.
+BEGIN;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS weight_unit TEXT NOT NULL DEFAULT 'kg';
+
+-- more decimals, so that weights entered in pounds are converted back without rounding errors
+ALTER TABLE sets ALTER COLUMN weight TYPE NUMERIC(10,4);
+
+COMMIT;
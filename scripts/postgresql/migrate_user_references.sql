@@ -0,0 +1,13 @@
+-- Makes the sets and workouts of a user refer to the user in databases created before they did, so that they
+-- are deleted with the user. Sets and workouts of users deleted earlier are removed. Run once.
+BEGIN;
+
+DELETE FROM sets WHERE user_id NOT IN (SELECT user_id FROM users);
+DELETE FROM workouts WHERE user_id NOT IN (SELECT user_id FROM users);
+
+ALTER TABLE sets DROP CONSTRAINT IF EXISTS sets_user_id_fkey;
+ALTER TABLE sets ADD CONSTRAINT sets_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE;
+ALTER TABLE workouts DROP CONSTRAINT IF EXISTS workouts_user_id_fkey;
+ALTER TABLE workouts ADD CONSTRAINT workouts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE;
+
+COMMIT;
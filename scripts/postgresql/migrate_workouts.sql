@@ -0,0 +1,20 @@
+-- Adds workouts and links sets to them, for databases created before workouts existed. Run once.
+-- Existing sets don't belong to any workout.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS workouts
+(
+    id SERIAL,
+    user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    started TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended TIMESTAMP WITH TIME ZONE,
+    notes TEXT NOT NULL DEFAULT '',
+    location TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE NOT NULL,
+    modified TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT workouts_pkey PRIMARY KEY (id)
+);
+
+ALTER TABLE sets ADD COLUMN IF NOT EXISTS workout_id INTEGER REFERENCES workouts(id) ON DELETE SET NULL;
+
+COMMIT;